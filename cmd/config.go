@@ -2,22 +2,473 @@ package cmd
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/notify"
+	"github.com/libri-gmbh/kube-vault/pkg/operator"
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+	"github.com/libri-gmbh/kube-vault/pkg/secretapi"
+	"github.com/libri-gmbh/kube-vault/pkg/termination"
+	"github.com/libri-gmbh/kube-vault/pkg/vault"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 type config struct {
-	KubeAuthRole      string `required:"true" split_words:"true"`
-	KubeAuthPath      string `default:"kubernetes" split_words:"true"`
-	KubeTokenFile     string `default:"/run/secrets/kubernetes.io/serviceaccount/token" split_words:"true"`
-	VaultTokenFile    string `default:"/env/vault-token" split_words:"true"`
-	EnvFile           string `default:"/env/secrets" split_words:"true"`
-	LeasesFile        string `default:"/env/secrets.leases.json" split_words:"true"`
-	ProcessorStrategy string `default:"env" split_words:"true"`
-	Verbose           bool   `default:"false" split_words:"true"`
+	AuthMethod          string            `default:"kubernetes" split_words:"true"`
+	KubeAuthRole        string            `split_words:"true"`
+	KubeAuthPath        string            `default:"kubernetes" split_words:"true"`
+	KubeTokenFile       string            `default:"/run/secrets/kubernetes.io/serviceaccount/token" split_words:"true"`
+	JWTAuthPath         string            `default:"jwt" split_words:"true"`
+	JWTAuthRole         string            `split_words:"true"`
+	JWTTokenFile        string            `default:"/var/run/secrets/tokens/vault-token" split_words:"true"`
+	ApproleAuthPath     string            `default:"approle" split_words:"true"`
+	ApproleRoleID       string            `split_words:"true"`
+	ApproleSecretID     string            `split_words:"true"`
+	ApproleSecretIDFile string            `split_words:"true"`
+	VaultTokenFile      string            `default:"/env/vault-token" split_words:"true"`
+	ConfigFile          string            `split_words:"true"`
+	EnvFile             string            `default:"/env/secrets" split_words:"true"`
+	EnvDialect          string            `default:"shell" split_words:"true"`
+	JSONFile            string            `default:"/env/secrets.json" split_words:"true"`
+	YAMLFile            string            `default:"/env/secrets.yaml" split_words:"true"`
+	FilesDir            string            `default:"/env/secrets" split_words:"true"`
+	FilesMode           string            `default:"0644" split_words:"true"`
+	FilesOwner          string            `split_words:"true"`
+	ListSeparator       string            `default:"," split_words:"true"`
+	NestedJSON          bool              `split_words:"true"`
+	PKIDir              string            `default:"/env/tls" split_words:"true"`
+	PKIReissueFraction  float64           `default:"0.66" split_words:"true"`
+	LeasesFile          string            `default:"/env/secrets.leases.json" split_words:"true"`
+	ProcessorStrategy   string            `default:"env" split_words:"true"`
+	Templates           []string          `split_words:"true"`
+	KubeSecretName      string            `split_words:"true"`
+	KubeSecretNamespace string            `split_words:"true"`
+	KubeSecretLabels    map[string]string `split_words:"true"`
+	KubeSecretOwner     string            `split_words:"true"`
+	OperatorNamespace   string            `split_words:"true"`
+	OperatorWorkers     int               `default:"2" split_words:"true"`
+	OperatorPaths       map[string]string `split_words:"true"`
+	SecretsSocket       string            `split_words:"true"`
+	SecretsSocketMode   string            `default:"0660" split_words:"true"`
+	SecretsSocketOwner  string            `split_words:"true"`
+	SecretsSocketUIDs   []int             `envconfig:"SECRETS_SOCKET_UIDS"`
+	SecretsSocketGIDs   []int             `envconfig:"SECRETS_SOCKET_GIDS"`
+	WebhookAddr         string            `default:":8443" split_words:"true"`
+	WebhookTLSCertFile  string            `default:"/etc/webhook/tls.crt" split_words:"true"`
+	WebhookTLSKeyFile   string            `default:"/etc/webhook/tls.key" split_words:"true"`
+	WebhookImage        string            `default:"libri/kube-vault" split_words:"true"`
+	WebhookVaultAddr    string            `split_words:"true"`
+	ReloadSignal        string            `default:"SIGHUP" split_words:"true"`
+	ReloadProcessName   string            `split_words:"true"`
+	ReloadPidFile       string            `split_words:"true"`
+	ReloadURL           string            `split_words:"true"`
+	ReloadCommand       string            `split_words:"true"`
+	ReloadAttempts      int               `default:"5" split_words:"true"`
+	ReloadRetryInterval time.Duration     `default:"5s" split_words:"true"`
+	HTTPAddr            string            `split_words:"true"`
+	RevokeOnShutdown    string            `default:"always" split_words:"true"`
+	RevokeTimeout       time.Duration     `default:"10s" split_words:"true"`
+	TerminatingFile     string            `default:"/env/terminating" split_words:"true"`
+	PodName             string            `split_words:"true"`
+	PodNamespace        string            `split_words:"true"`
+	RetryMaxAttempts    int               `default:"5" split_words:"true"`
+	RetryBaseDelay      time.Duration     `default:"1s" split_words:"true"`
+	RetryMaxDelay       time.Duration     `default:"30s" split_words:"true"`
+	RetryJitter         float64           `default:"0.2" split_words:"true"`
+	RetryStatusCodes    []int             `default:"412,429,500,502,503,504" split_words:"true"`
+	Verbose             bool              `default:"false" split_words:"true"`
+}
+
+// newAuthMethod returns the vault auth method selected by AUTH_METHOD
+func (c *config) newAuthMethod() (vault.AuthMethod, error) {
+	switch c.AuthMethod {
+	case "kubernetes":
+		if c.KubeAuthRole == "" {
+			return nil, errors.New("required key KUBE_AUTH_ROLE missing value")
+		}
+		return vault.NewKubernetesAuth(c.KubeAuthPath, c.KubeAuthRole, c.KubeTokenFile), nil
+
+	case "jwt":
+		return vault.NewJWTAuth(c.JWTAuthPath, c.JWTAuthRole, c.JWTTokenFile), nil
+
+	case "approle":
+		if c.ApproleRoleID == "" {
+			return nil, errors.New("required key APPROLE_ROLE_ID missing value")
+		}
+		if c.ApproleSecretID == "" && c.ApproleSecretIDFile == "" {
+			return nil, errors.New("required key APPROLE_SECRET_ID or APPROLE_SECRET_ID_FILE missing value")
+		}
+		return vault.NewAppRoleAuth(c.ApproleAuthPath, c.ApproleRoleID, c.ApproleSecretID, c.ApproleSecretIDFile), nil
+
+	default:
+		return nil, fmt.Errorf("undefined auth method %q. Possible values: [kubernetes jwt approle]", c.AuthMethod)
+	}
+}
+
+// newProcessor returns the processor configured by CONFIG_FILE or selected by PROCESSOR_STRATEGY. The given observer
+// is notified of the secrets rendered by the env output, if not nil.
+func (c *config) newProcessor(logger *logrus.Entry, observer processor.Observer) (processor.Processor, error) {
+	if c.ConfigFile == "" {
+		output, err := c.newOutput(logger, c.strategyOutput())
+		if err != nil {
+			return nil, err
+		}
+		return output, observe(output, observer)
+	}
+
+	file, err := loadConfigFile(c.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := file.Outputs
+	if len(configs) == 0 {
+		configs = []*outputConfig{c.strategyOutput()}
+	}
+
+	var outputs []processor.Output
+	var observed bool
+	for _, config := range configs {
+		output, err := c.newOutput(logger, config)
+		if err != nil {
+			return nil, err
+		}
+		if env, ok := output.(*processor.Env); ok && observer != nil && !observed {
+			env.Observe(observer)
+			observed = true
+		}
+		outputs = append(outputs, output)
+	}
+
+	if observer != nil && !observed {
+		return nil, errors.New("the secrets socket requires an env output")
+	}
+
+	return processor.NewMulti(logger, file.Secrets, os.Environ(), outputs, c.valueFormat(), c.LeasesFile), nil
+}
+
+// observe sets the observer of the given output, which needs to be an env output if an observer is given
+func observe(output processor.Output, observer processor.Observer) error {
+	if observer == nil {
+		return nil
+	}
+
+	env, ok := output.(*processor.Env)
+	if !ok {
+		return errors.New("the secrets socket requires the env processor strategy")
+	}
+
+	env.Observe(observer)
+
+	return nil
+}
+
+// newSecretsServer returns the server serving the secrets of the given store on SECRETS_SOCKET
+func (c *config) newSecretsServer(logger *logrus.Entry, store *secretapi.Store) (*secretapi.Server, error) {
+	mode, err := strconv.ParseUint(c.SecretsSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SECRETS_SOCKET_MODE %q: %v", c.SecretsSocketMode, err)
+	}
+
+	uid, gid, err := parseOwner(c.SecretsSocketOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return secretapi.NewServer(logger, c.SecretsSocket, os.FileMode(mode), uid, gid, c.SecretsSocketUIDs, c.SecretsSocketGIDs, store), nil
+}
+
+// configSecrets returns the secrets of the config file, none if no config file is given
+func (c *config) configSecrets() ([]*processor.Secret, error) {
+	if c.ConfigFile == "" {
+		return nil, nil
+	}
+
+	file, err := loadConfigFile(c.ConfigFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return file.Secrets, nil
+}
+
+// strategyOutput returns the output selected by PROCESSOR_STRATEGY, configured by the env vars
+func (c *config) strategyOutput() *outputConfig {
+	output := &outputConfig{
+		Type:      c.ProcessorStrategy,
+		Dir:       c.FilesDir,
+		Mode:      c.FilesMode,
+		Owner:     c.FilesOwner,
+		Templates: c.Templates,
+	}
+
+	switch c.ProcessorStrategy {
+	case "env":
+		output.File = c.EnvFile
+	case "json":
+		output.File = c.JSONFile
+	case "yaml":
+		output.File = c.YAMLFile
+	case "pki":
+		output.Dir = c.PKIDir
+	case "kubernetes-secret":
+		output.Name = c.KubeSecretName
+		output.Namespace = c.KubeSecretNamespace
+		output.Labels = c.KubeSecretLabels
+		output.OwnerReference = c.KubeSecretOwner
+	}
+
+	return output
+}
+
+// newOutput returns the processor writing the given output
+func (c *config) newOutput(logger *logrus.Entry, output *outputConfig) (processor.Output, error) {
+	switch output.Type {
+	case "env", "json", "yaml":
+		if output.File == "" {
+			return nil, fmt.Errorf("no file given for the %s output", output.Type)
+		}
+	case "files", "pki":
+		if output.Dir == "" {
+			return nil, fmt.Errorf("no dir given for the %s output", output.Type)
+		}
+	}
+
+	switch output.Type {
+	case "env":
+		dialect := output.Dialect
+		if dialect == "" {
+			dialect = c.EnvDialect
+		}
+		if !processor.ValidDialect(dialect) {
+			return nil, fmt.Errorf("undefined dialect %q of the env output. Possible values: [shell dotenv systemd fish]", dialect)
+		}
+
+		return processor.NewEnv(logger, os.Environ(), output.File, dialect, c.valueFormat(), c.LeasesFile), nil
+
+	case "json":
+		return processor.NewJSON(logger, os.Environ(), output.File, c.LeasesFile), nil
+
+	case "yaml":
+		return processor.NewYAML(logger, os.Environ(), output.File, c.LeasesFile), nil
+
+	case "files", "pki":
+		mode, err := strconv.ParseUint(output.Mode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode %q of the %s output: %v", output.Mode, output.Type, err)
+		}
+
+		uid, gid, err := parseOwner(output.Owner)
+		if err != nil {
+			return nil, err
+		}
+
+		if output.Type == "files" {
+			return processor.NewFiles(logger, os.Environ(), output.Dir, os.FileMode(mode), uid, gid, c.valueFormat(), c.LeasesFile), nil
+		}
+
+		fraction := output.ReissueFraction
+		if fraction == 0 {
+			fraction = c.PKIReissueFraction
+		}
+		if fraction <= 0 || fraction >= 1 {
+			return nil, fmt.Errorf("invalid reissue fraction %v of the pki output, must be between 0 and 1", fraction)
+		}
+
+		return processor.NewPKI(logger, os.Environ(), output.Dir, os.FileMode(mode), uid, gid, fraction, c.LeasesFile), nil
+
+	case "kubernetes-secret":
+		return c.newKubernetesSecret(logger, output)
+
+	case "template":
+		if len(output.Templates) == 0 {
+			return nil, errors.New("required key TEMPLATES missing value")
+		}
+		return processor.NewTemplate(logger, output.Templates, c.LeasesFile), nil
+
+	default:
+		return nil, fmt.Errorf("undefined strategy %q. Possible values: [env json yaml files pki kubernetes-secret template]", output.Type)
+	}
+}
+
+// valueFormat returns the format the env and files outputs convert secret values with
+func (c *config) valueFormat() processor.ValueFormat {
+	return processor.ValueFormat{
+		ListSeparator: c.ListSeparator,
+		NestedJSON:    c.NestedJSON,
+	}
+}
+
+// operatorPaths returns the vault path prefixes the VaultSecrets of each namespace may read from, or write to if
+// marked with "write:"
+func (c *config) operatorPaths() operator.PathPrefixes {
+	prefixes := operator.PathPrefixes{}
+	for namespace, paths := range c.OperatorPaths {
+		prefixes[namespace] = strings.Fields(paths)
+	}
+
+	return prefixes
+}
+
+// newKubernetesSecret returns the processor writing the given kubernetes secret output using the in-cluster client
+func (c *config) newKubernetesSecret(logger *logrus.Entry, output *outputConfig) (processor.Output, error) {
+	if output.Name == "" {
+		return nil, errors.New("no name given for the kubernetes-secret output")
+	}
+
+	namespace := output.Namespace
+	if namespace == "" {
+		namespace = c.PodNamespace
+	}
+	if namespace == "" {
+		// nolint: gosec
+		content, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("no namespace given for the kubernetes-secret output and failed to read the one of the service account: %v", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+
+	var owner *metav1.OwnerReference
+	if output.OwnerReference != "" {
+		var err error
+		owner, err = parseOwnerReference(output.OwnerReference)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the kubernetes client: %v", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the kubernetes client: %v", err)
+	}
+
+	return processor.NewKubernetesSecret(logger, os.Environ(), client, namespace, output.Name, output.Labels, owner, c.valueFormat(), c.LeasesFile), nil
+}
+
+// parseOwnerReference parses an owner reference given as "apiVersion/kind/name/uid", e.g. "apps/v1/Deployment/app/uid"
+func parseOwnerReference(reference string) (*metav1.OwnerReference, error) {
+	parts := strings.Split(reference, "/")
+	n := len(parts)
+	if n < 4 {
+		return nil, fmt.Errorf("invalid owner reference %q, expected apiVersion/kind/name/uid", reference)
+	}
+
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid owner reference %q, expected apiVersion/kind/name/uid", reference)
+		}
+	}
+
+	return &metav1.OwnerReference{
+		APIVersion: strings.Join(parts[:n-3], "/"),
+		Kind:       parts[n-3],
+		Name:       parts[n-2],
+		UID:        types.UID(parts[n-1]),
+	}, nil
+}
+
+// parseOwner parses a files owner given as "uid:gid", returning -1 for the ids not given
+func parseOwner(owner string) (int, int, error) {
+	if owner == "" {
+		return -1, -1, nil
+	}
+
+	parts := strings.SplitN(owner, ":", 2)
+	uid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid in owner %q: %v", owner, err)
+	}
+
+	gid := -1
+	if len(parts) == 2 {
+		gid, err = strconv.Atoi(parts[1])
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid gid in owner %q: %v", owner, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// newReloadHooks returns the hooks notifying the application about re-rendered secrets
+func (c *config) newReloadHooks(logger *logrus.Entry) (*notify.Hooks, error) {
+	var notifiers []notify.Notifier
+
+	if c.ReloadProcessName != "" || c.ReloadPidFile != "" {
+		sig, err := notify.ParseSignal(c.ReloadSignal)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RELOAD_SIGNAL: %v", err)
+		}
+		notifiers = append(notifiers, notify.NewSignal(sig, c.ReloadProcessName, c.ReloadPidFile))
+	}
+
+	if c.ReloadURL != "" {
+		notifiers = append(notifiers, notify.NewHTTP(c.ReloadURL))
+	}
+
+	if c.ReloadCommand != "" {
+		notifiers = append(notifiers, notify.NewCommand(c.ReloadCommand))
+	}
+
+	return notify.NewHooks(logger, c.ReloadAttempts, c.ReloadRetryInterval, notifiers...), nil
+}
+
+// newRetryPolicy returns the policy used to retry failed vault calls
+func (c *config) newRetryPolicy(logger *logrus.Entry) *retry.Policy {
+	return retry.NewPolicy(logger, c.RetryMaxAttempts, c.RetryBaseDelay, c.RetryMaxDelay, c.RetryJitter, c.RetryStatusCodes)
+}
+
+// revokeOnShutdown returns the func deciding by REVOKE_ON_SHUTDOWN whether the auth token and the leases are revoked
+// once the renew container shuts down
+func (c *config) revokeOnShutdown(logger *logrus.Entry) (func() bool, error) {
+	switch c.RevokeOnShutdown {
+	case "always":
+		return func() bool { return true }, nil
+
+	case "never":
+		return func() bool { return false }, nil
+
+	case "terminating":
+		file := termination.NewFile(c.TerminatingFile)
+		if err := file.Clear(); err != nil {
+			return nil, fmt.Errorf("failed to remove trigger file of a previous run: %v", err)
+		}
+
+		detectors := []termination.Detector{file}
+		if c.PodName != "" {
+			pod, err := termination.NewInClusterPod(c.PodNamespace, c.PodName)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure pod termination detection: %v", err)
+			}
+			detectors = append(detectors, pod)
+		}
+
+		return func() bool { return termination.Terminating(logger, detectors...) }, nil
+
+	default:
+		return nil, fmt.Errorf("undefined revoke mode %q. Possible values: [always never terminating]", c.RevokeOnShutdown)
+	}
 }
 
 func newExitHandlerContext(logger *logrus.Entry) context.Context {
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/libri-gmbh/kube-vault/pkg/vault"
+)
+
+func TestConfig_NewAuthMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   config
+		expected vault.AuthMethod
+		fails    bool
+	}{
+		{
+			name:     "kubernetes",
+			config:   config{AuthMethod: "kubernetes", KubeAuthRole: "app"},
+			expected: &vault.KubernetesAuth{},
+		},
+		{name: "kubernetes without role", config: config{AuthMethod: "kubernetes"}, fails: true},
+		{name: "jwt", config: config{AuthMethod: "jwt", JWTAuthRole: "app"}, expected: &vault.JWTAuth{}},
+		{
+			name:     "approle with secret id",
+			config:   config{AuthMethod: "approle", ApproleRoleID: "role", ApproleSecretID: "secret"},
+			expected: &vault.AppRoleAuth{},
+		},
+		{
+			name:     "approle with secret id file",
+			config:   config{AuthMethod: "approle", ApproleRoleID: "role", ApproleSecretIDFile: "/run/secrets/secret-id"},
+			expected: &vault.AppRoleAuth{},
+		},
+		{name: "approle without role id", config: config{AuthMethod: "approle", ApproleSecretID: "secret"}, fails: true},
+		{name: "approle without secret id", config: config{AuthMethod: "approle", ApproleRoleID: "role"}, fails: true},
+		{name: "unknown", config: config{AuthMethod: "ldap"}, fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method, err := test.config.newAuthMethod()
+			if test.fails {
+				if err == nil {
+					t.Errorf("Expected an error, got auth method %v", method)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error from newAuthMethod(): %v", err)
+			}
+
+			if reflect.TypeOf(method) != reflect.TypeOf(test.expected) {
+				t.Errorf("Expected auth method %T, got %T", test.expected, method)
+			}
+		})
+	}
+}
+
+func TestConfig_DefaultAuthMethod(t *testing.T) {
+	for key, value := range map[string]string{"AUTH_METHOD": "", "KUBE_AUTH_ROLE": "app"} {
+		previous, ok := os.LookupEnv(key)
+		if value == "" {
+			_ = os.Unsetenv(key)
+		} else {
+			_ = os.Setenv(key, value)
+		}
+		defer func(key, previous string, ok bool) {
+			if ok {
+				_ = os.Setenv(key, previous)
+			} else {
+				_ = os.Unsetenv(key)
+			}
+		}(key, previous, ok)
+	}
+
+	var c config
+	if err := envconfig.Process("", &c); err != nil {
+		t.Fatalf("Got unexpected error from envconfig.Process(): %v", err)
+	}
+
+	method, err := c.newAuthMethod()
+	if err != nil {
+		t.Fatalf("Got unexpected error from newAuthMethod(): %v", err)
+	}
+	if _, ok := method.(*vault.KubernetesAuth); !ok {
+		t.Errorf("Expected the kubernetes auth method by default, got %T", method)
+	}
+	if method.LoginPath() != "/v1/auth/kubernetes/login" {
+		t.Errorf("Expected the default login path %q, got %q", "/v1/auth/kubernetes/login", method.LoginPath())
+	}
+}
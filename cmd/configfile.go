@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"gopkg.in/yaml.v2"
+)
+
+// fileConfig is the schema of the yaml file given by CONFIG_FILE
+type fileConfig struct {
+	Secrets []*processor.Secret `yaml:"secrets"`
+	// Outputs are rendered from a single read of the secrets, the output selected by PROCESSOR_STRATEGY is used if
+	// none is given
+	Outputs []*outputConfig `yaml:"outputs"`
+}
+
+// outputConfig configures an output of the config file, the options used depend on the type
+type outputConfig struct {
+	// Type is one of the processor strategies
+	Type string `yaml:"type"`
+	// File is used by the env, json and yaml outputs
+	File string `yaml:"file"`
+	// Dialect is the format of the env output, defaults to ENV_DIALECT
+	Dialect string `yaml:"dialect"`
+	// Dir, Mode and Owner are used by the files and pki outputs
+	Dir   string `yaml:"dir"`
+	Mode  string `yaml:"mode"`
+	Owner string `yaml:"owner"`
+	// ReissueFraction is the fraction of the lifetime after which the pki output reissues certificates, defaults to
+	// PKI_REISSUE_FRACTION
+	ReissueFraction float64 `yaml:"reissue_fraction"`
+	// Templates are used by the template output, given as "source:destination" pairs
+	Templates []string `yaml:"templates"`
+	// Name, Namespace, Labels and OwnerReference are used by the kubernetes-secret output, the owner reference is
+	// given as "apiVersion/kind/name/uid"
+	Name           string            `yaml:"name"`
+	Namespace      string            `yaml:"namespace"`
+	Labels         map[string]string `yaml:"labels"`
+	OwnerReference string            `yaml:"owner_reference"`
+}
+
+// loadConfigFile reads the config file, unknown keys are rejected to reveal typos
+func loadConfigFile(path string) (*fileConfig, error) {
+	// nolint: gosec
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	config := &fileConfig{}
+	if err := yaml.UnmarshalStrict(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %q: %v", path, err)
+	}
+
+	for _, output := range config.Outputs {
+		if output.Mode == "" {
+			output.Mode = "0644"
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,149 @@
+// Copyright © 2018 Alexander Pinnecke <alexander.pinnecke@googlemail.com>
+
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+	"github.com/libri-gmbh/kube-vault/pkg/vault"
+	"github.com/spf13/cobra"
+)
+
+// execCmd represents the exec command
+var execCmd = &cobra.Command{
+	Use:                "exec -- command [args...]",
+	Short:              "Run a command with the secrets injected into its environment, renewing the leases while it runs",
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := baseLogger.WithField("cmd", "exec")
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			logger.Fatal("no command given to execute")
+		}
+
+		method, err := cfg.newAuthMethod()
+		if err != nil {
+			baseLogger.Fatalf("failed to configure vault auth method: %v", err)
+		}
+
+		// the signals are forwarded to the child once it is started, the context is canceled after it exited
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		// the token is not handed over to another container, so no token file is used
+		policy := cfg.newRetryPolicy(logger)
+		auth := vault.NewAuthenticator(logger, client, policy)
+		_, err = auth.Authenticate(ctx, true, method, "")
+		if err != nil {
+			baseLogger.Fatalf("failed to authenticate with vault: %v", err)
+		}
+
+		secrets, err := cfg.configSecrets()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		env := processor.NewMulti(logger, secrets, os.Environ(), nil, cfg.valueFormat(), "")
+		variables, leases, err := env.Variables(retry.NewLogical(ctx, policy, client.Logical()))
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		// nolint: gosec
+		child := exec.Command(args[0], args[1:]...)
+		child.Env = append(os.Environ(), variables...)
+		child.Stdin = os.Stdin
+		child.Stdout = os.Stdout
+		child.Stderr = os.Stderr
+
+		if err := child.Start(); err != nil {
+			logger.Fatalf("failed to start %q: %v", args[0], err)
+		}
+
+		renewDone := make(chan struct{})
+		go func() {
+			defer close(renewDone)
+			login := func() (*api.Secret, error) {
+				return auth.Authenticate(ctx, true, method, "")
+			}
+
+			// the environment of the running child can not be changed, so secrets are not rotated
+			leaseManager := lease.NewManager(logger, client, policy, login, nil)
+			leaseManager.Renew(ctx, leases)
+
+			// the secrets were passed to the child only, so they are not needed anymore
+			leaseManager.Revoke(cfg.RevokeTimeout)
+		}()
+
+		stopForwarding := forwardSignals(logger, child.Process)
+		exitCode := childExitCode(child.Wait())
+		stopForwarding()
+
+		logger.Infof("%q exited with code %d", args[0], exitCode)
+
+		cancel()
+		<-renewDone
+
+		os.Exit(exitCode)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(execCmd)
+}
+
+// forwardSignals relays the signals received by kube-vault to the given process until the returned func is called
+func forwardSignals(logger *logrus.Entry, process *os.Process) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				logger.Debugf("forwarding signal %v", sig)
+				if err := process.Signal(sig); err != nil {
+					logger.Errorf("failed to forward signal %v: %v", sig, err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// childExitCode returns the exit code of a child process from the error returned by Wait, following the shell
+// convention of 128 + signal number for processes killed by a signal
+func childExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			if status.Signaled() {
+				return 128 + int(status.Signal())
+			}
+			return status.ExitStatus()
+		}
+	}
+
+	return 1
+}
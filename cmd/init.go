@@ -3,9 +3,7 @@
 package cmd
 
 import (
-	"os"
-
-	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
 	"github.com/libri-gmbh/kube-vault/pkg/vault"
 	"github.com/spf13/cobra"
 )
@@ -16,22 +14,27 @@ var initCmd = &cobra.Command{
 	Short: "Run the sidecar as init container to fetch secrets and store credentials",
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := baseLogger.WithField("cmd", "init")
-		auth := vault.NewAuthenticator(logger, client)
-		_, err := auth.Authenticate(true, cfg.KubeAuthPath, cfg.KubeAuthRole, cfg.KubeTokenFile, cfg.VaultTokenFile)
+		method, err := cfg.newAuthMethod()
+		if err != nil {
+			baseLogger.Fatalf("failed to configure vault auth method: %v", err)
+		}
+
+		ctx := newExitHandlerContext(logger)
+		policy := cfg.newRetryPolicy(logger)
+		auth := vault.NewAuthenticator(logger, client, policy)
+		_, err = auth.Authenticate(ctx, true, method, cfg.VaultTokenFile)
 		if err != nil {
 			baseLogger.Fatalf("failed to authenticate with vault: %v", err)
 		}
 
-		switch cfg.ProcessorStrategy {
-		case "env":
-			env := processor.NewEnv(logger, os.Environ(), cfg.EnvFile, cfg.LeasesFile)
-			err = env.Process(client.Logical())
-			if err != nil {
-				logger.Fatal(err)
-			}
+		proc, err := cfg.newProcessor(logger, nil)
+		if err != nil {
+			logger.Fatal(err)
+		}
 
-		default:
-			logger.Fatalf("Undefined strategy %q. Possible values: [env]", cfg.ProcessorStrategy)
+		err = proc.Process(retry.NewLogical(ctx, policy, client.Logical()))
+		if err != nil {
+			logger.Fatal(err)
 		}
 	},
 }
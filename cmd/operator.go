@@ -0,0 +1,80 @@
+// Copyright © 2018 Alexander Pinnecke <alexander.pinnecke@googlemail.com>
+
+package cmd
+
+import (
+	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"github.com/libri-gmbh/kube-vault/pkg/operator"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+	"github.com/libri-gmbh/kube-vault/pkg/server"
+	"github.com/libri-gmbh/kube-vault/pkg/vault"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// operatorCmd represents the operator command
+var operatorCmd = &cobra.Command{
+	Use:   "operator",
+	Short: "Sync the kubernetes secrets declared by VaultSecret resources",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := baseLogger.WithField("cmd", "operator")
+		method, err := cfg.newAuthMethod()
+		if err != nil {
+			baseLogger.Fatalf("failed to configure vault auth method: %v", err)
+		}
+
+		ctx := newExitHandlerContext(logger)
+		policy := cfg.newRetryPolicy(logger)
+		auth := vault.NewAuthenticator(logger, client, policy)
+		if _, err := auth.Authenticate(ctx, true, method, ""); err != nil {
+			baseLogger.Fatalf("failed to authenticate with vault: %v", err)
+		}
+
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			logger.Fatalf("failed to configure the kubernetes client: %v", err)
+		}
+
+		kubeClient, err := kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			logger.Fatalf("failed to create the kubernetes client: %v", err)
+		}
+
+		dynamicClient, err := dynamic.NewForConfig(restConfig)
+		if err != nil {
+			logger.Fatalf("failed to create the kubernetes client: %v", err)
+		}
+
+		controller := operator.NewController(logger, dynamicClient, kubeClient, client, retry.NewLogical(ctx, policy, client.Logical()), policy, cfg.valueFormat(), cfg.operatorPaths())
+
+		// vault revokes the leases along with the auth token which created them, so all secrets are read again
+		// using the new auth token
+		login := func() (*api.Secret, error) {
+			secret, err := auth.Authenticate(ctx, true, method, "")
+			if err == nil {
+				controller.Reset()
+			}
+			return secret, err
+		}
+
+		tokenManager := lease.NewManager(logger, client, policy, login, nil)
+		if cfg.HTTPAddr != "" {
+			server.NewServer(logger, cfg.HTTPAddr, tokenManager).Start(ctx)
+		}
+
+		// the auth token is not revoked on shutdown, as the leases written into the kubernetes secrets would be
+		// revoked along with it
+		go tokenManager.Renew(ctx, nil)
+
+		if err := controller.Run(ctx, cfg.OperatorNamespace, cfg.OperatorWorkers); err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(operatorCmd)
+}
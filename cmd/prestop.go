@@ -0,0 +1,27 @@
+// Copyright © 2018 Alexander Pinnecke <alexander.pinnecke@googlemail.com>
+
+package cmd
+
+import (
+	"github.com/libri-gmbh/kube-vault/pkg/termination"
+	"github.com/spf13/cobra"
+)
+
+// prestopCmd represents the prestop command
+var prestopCmd = &cobra.Command{
+	Use:   "prestop",
+	Short: "Tell the renew container that the pod is terminating, to be used as its preStop hook",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := baseLogger.WithField("cmd", "prestop")
+
+		if err := termination.NewFile(cfg.TerminatingFile).Trigger(); err != nil {
+			logger.Fatalf("failed to create trigger file: %v", err)
+		}
+
+		logger.Infof("Created trigger file %s", cfg.TerminatingFile)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(prestopCmd)
+}
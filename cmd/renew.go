@@ -3,8 +3,14 @@
 package cmd
 
 import (
+	"github.com/hashicorp/vault/api"
 	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+	"github.com/libri-gmbh/kube-vault/pkg/secretapi"
+	"github.com/libri-gmbh/kube-vault/pkg/server"
 	"github.com/libri-gmbh/kube-vault/pkg/vault"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 )
 
@@ -14,18 +20,100 @@ var renewCmd = &cobra.Command{
 	Short: "Renew the leases created by the init process",
 	Run: func(cmd *cobra.Command, args []string) {
 		logger := baseLogger.WithField("cmd", "renew")
-		auth := vault.NewAuthenticator(logger, client)
-		_, err := auth.Authenticate(false, cfg.KubeAuthPath, cfg.KubeAuthRole, cfg.KubeTokenFile, cfg.VaultTokenFile)
+		method, err := cfg.newAuthMethod()
 		if err != nil {
-			baseLogger.Fatalf("failed to authenticate with vault: %v", err)
+			baseLogger.Fatalf("failed to configure vault auth method: %v", err)
 		}
 
 		ctx := newExitHandlerContext(logger)
-		leaseManager := lease.NewManager(logger, client)
+		policy := cfg.newRetryPolicy(logger)
+		auth := vault.NewAuthenticator(logger, client, policy)
+		_, err = auth.Authenticate(ctx, false, method, cfg.VaultTokenFile)
+		if err != nil {
+			baseLogger.Fatalf("failed to authenticate with vault: %v", err)
+		}
+
+		var store *secretapi.Store
+		if cfg.SecretsSocket != "" {
+			store = secretapi.NewStore()
+		}
+
+		proc, err := cfg.newProcessor(logger, observer(store))
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		hooks, err := cfg.newReloadHooks(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		revoke, err := cfg.revokeOnShutdown(logger)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		rotate := func(leases []*lease.Lease) ([]*lease.Lease, error) {
+			leases, err := proc.Refresh(retry.NewLogical(ctx, policy, client.Logical()), leases)
+			if err != nil {
+				return nil, err
+			}
+
+			go hooks.Run()
+
+			return leases, nil
+		}
+
+		login := func() (*api.Secret, error) {
+			return auth.Authenticate(ctx, true, method, cfg.VaultTokenFile)
+		}
+
+		if store != nil {
+			// the secrets are not kept by the init container, so they are rendered again reusing its leases
+			leases, err := lease.LoadLeases(cfg.LeasesFile)
+			if err != nil {
+				logger.Fatal(err)
+			}
+
+			if _, err := proc.Refresh(retry.NewLogical(ctx, policy, client.Logical()), leases); err != nil {
+				logger.Fatalf("failed to read the secrets served on the secrets socket: %v", err)
+			}
+
+			secretsServer, err := cfg.newSecretsServer(logger, store)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			if err := secretsServer.Start(ctx); err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		leaseManager := lease.NewManager(logger, client, policy, login, rotate)
+		if cfg.HTTPAddr != "" {
+			prometheus.MustRegister(lease.NewCollector(leaseManager))
+			server.NewServer(logger, cfg.HTTPAddr, leaseManager).Start(ctx)
+		}
+
 		leaseManager.StartRenew(ctx, cfg.LeasesFile)
+
+		if !revoke() {
+			logger.Info("Keeping the auth token and leases for the next run")
+			return
+		}
+
+		leaseManager.Revoke(cfg.RevokeTimeout)
 	},
 }
 
+// observer returns the given store as observer, nil if the store is nil
+func observer(store *secretapi.Store) processor.Observer {
+	if store == nil {
+		return nil
+	}
+
+	return store
+}
+
 func init() {
 	RootCmd.AddCommand(renewCmd)
 }
@@ -43,7 +43,11 @@ var RootCmd = &cobra.Command{
 			baseLogger.SetLevel(logrus.InfoLevel)
 		}
 
-		client, err = api.NewClient(api.DefaultConfig())
+		// failed requests are retried by the retry policy only, which knows the writes which must not be repeated
+		vaultConfig := api.DefaultConfig()
+		vaultConfig.MaxRetries = 0
+
+		client, err = api.NewClient(vaultConfig)
 		if err != nil {
 			baseLogger.Fatalf("Failed to create vault client: %v", err)
 		}
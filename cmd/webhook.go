@@ -0,0 +1,33 @@
+// Copyright © 2018 Alexander Pinnecke <alexander.pinnecke@googlemail.com>
+
+package cmd
+
+import (
+	"github.com/libri-gmbh/kube-vault/pkg/webhook"
+	"github.com/spf13/cobra"
+)
+
+// webhookCmd represents the webhook command
+var webhookCmd = &cobra.Command{
+	Use:   "webhook",
+	Short: "Serve a mutating admission webhook injecting the init and renew containers into annotated pods",
+	Run: func(cmd *cobra.Command, args []string) {
+		logger := baseLogger.WithField("cmd", "webhook")
+
+		vaultAddr := cfg.WebhookVaultAddr
+		if vaultAddr == "" {
+			vaultAddr = client.Address()
+		}
+
+		injector := webhook.NewInjector(cfg.WebhookImage, vaultAddr)
+		ctx := newExitHandlerContext(logger)
+
+		if err := webhook.NewWebhook(logger, cfg.WebhookAddr, cfg.WebhookTLSCertFile, cfg.WebhookTLSKeyFile, injector).Run(ctx); err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(webhookCmd)
+}
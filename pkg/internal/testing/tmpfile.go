@@ -29,3 +29,20 @@ func CreateTempFile(logger *logrus.Entry) (string, func(), error) {
 
 	return tmpfile.Name(), clean, nil
 }
+
+// CreateTempDir creates a temporary directory and returns the path and a callback to delete the directory including
+// its content (should be called with defer by the caller)
+func CreateTempDir(logger *logrus.Entry) (string, func(), error) {
+	dir, err := ioutil.TempDir("", "kube_vault_sidecar_test")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %v", err)
+	}
+
+	clean := func() {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Fatalf("failed to clean up temp dir: %v", err)
+		}
+	}
+
+	return dir, clean, nil
+}
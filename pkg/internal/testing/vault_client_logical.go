@@ -6,6 +6,12 @@ import "github.com/hashicorp/vault/api"
 type VaultClientLogical struct {
 	Result      *api.Secret
 	ResultError error
+	// PathResults optionally holds results per path, Result is returned for paths not contained
+	PathResults map[string]*api.Secret
+	// Written holds the data of the last write per path
+	Written map[string]map[string]interface{}
+	// Reads holds the number of reads per path
+	Reads map[string]int
 }
 
 // NewVaultClientLogical returns a new VaultClientLogical instance
@@ -26,13 +32,26 @@ func (c *VaultClientLogical) List(path string) (*api.Secret, error) {
 	return c.Result, c.ResultError
 }
 
-// Read just returns the results set on the struct
+// Read returns the result set for the given path or the results set on the struct
 func (c *VaultClientLogical) Read(path string) (*api.Secret, error) {
-	return c.Result, c.ResultError
+	if c.Reads == nil {
+		c.Reads = map[string]int{}
+	}
+	c.Reads[path]++
+
+	return c.pathResult(path)
 }
 
-// ReadWithData just returns the results set on the struct
+// ReadWithData returns the result set for the given path or the results set on the struct
 func (c *VaultClientLogical) ReadWithData(path string, data map[string][]string) (*api.Secret, error) {
+	return c.pathResult(path)
+}
+
+func (c *VaultClientLogical) pathResult(path string) (*api.Secret, error) {
+	if result, ok := c.PathResults[path]; ok {
+		return result, c.ResultError
+	}
+
 	return c.Result, c.ResultError
 }
 
@@ -41,7 +60,12 @@ func (c *VaultClientLogical) Unwrap(wrappingToken string) (*api.Secret, error) {
 	return c.Result, c.ResultError
 }
 
-// Write just returns the results set on the struct
+// Write records the written data and returns the result set for the given path or the results set on the struct
 func (c *VaultClientLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
-	return c.Result, c.ResultError
+	if c.Written == nil {
+		c.Written = map[string]map[string]interface{}{}
+	}
+	c.Written[path] = data
+
+	return c.pathResult(path)
 }
@@ -0,0 +1,38 @@
+package lease
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var expiryDesc = prometheus.NewDesc(
+	"kube_vault_lease_expiry_seconds",
+	"Seconds until the lease of a secret expires.",
+	[]string{"name"}, nil,
+)
+
+// Collector exposes the seconds until each lease of the manager expires, labelled by the SECRET_ names referencing
+// the lease. The values are taken from the manager on each scrape.
+type Collector struct {
+	manager *Manager
+}
+
+// NewCollector returns a new Collector instance
+func NewCollector(manager *Manager) *Collector {
+	return &Collector{
+		manager: manager,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- expiryDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range c.manager.Status().Leases {
+		for _, name := range status.Names {
+			ch <- prometheus.MustNewConstMetric(expiryDesc, prometheus.GaugeValue, float64(status.TTL), name)
+		}
+	}
+}
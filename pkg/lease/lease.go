@@ -0,0 +1,73 @@
+package lease
+
+import (
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+// Lease is a secret as stored in the leases file, along with the reference it was read from. The secret fields are
+// embedded, so leases files written before the reference was stored are still readable.
+type Lease struct {
+	*api.Secret
+	// Path is the reference the secret was read from, e.g. "kv2:kv/app/db?version=3"
+	Path string `json:"path,omitempty"`
+	// Names are the SECRET_ env var names referencing the secret
+	Names []string `json:"names,omitempty"`
+	// LastRenewed is the time the lease was renewed the last time, nil if it was not renewed yet
+	LastRenewed *time.Time `json:"last_renewed,omitempty"`
+	// ExpiresAt is the time the lease expires, as known by the time it was read or renewed the last time
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RotateAt is the time the secret gets fetched again regardless of its lease, e.g. to reissue a certificate before
+	// it expires
+	RotateAt *time.Time `json:"rotate_at,omitempty"`
+}
+
+// NewLease returns a new Lease instance of a secret read just now
+func NewLease(secret *api.Secret, path string) *Lease {
+	l := &Lease{
+		Secret: secret,
+		Path:   path,
+	}
+
+	if l.leased() {
+		l.setExpiry(time.Now(), l.LeaseDuration)
+	}
+
+	return l
+}
+
+// AddName adds the given SECRET_ env var name to the names referencing the secret
+func (l *Lease) AddName(name string) {
+	for _, n := range l.Names {
+		if n == name {
+			return
+		}
+	}
+
+	l.Names = append(l.Names, name)
+}
+
+// id returns the lease id, or the path of secrets which are rotated without a lease
+func (l *Lease) id() string {
+	if l.Secret != nil && l.LeaseID != "" {
+		return l.LeaseID
+	}
+
+	return l.Path
+}
+
+// leased returns whether the secret has a lease which expires
+func (l *Lease) leased() bool {
+	return l.Secret != nil && l.LeaseID != "" && (l.Renewable || l.LeaseDuration > 0)
+}
+
+// expired returns whether the lease expired at the given time, leases of unknown expiry are not expired
+func (l *Lease) expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+func (l *Lease) setExpiry(now time.Time, ttl int) {
+	expiresAt := now.Add(time.Duration(ttl) * time.Second)
+	l.ExpiresAt = &expiresAt
+}
@@ -5,28 +5,73 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/metrics"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
 )
 
+const (
+	// authTokenIncrement is the amount of seconds the auth token is extended by on each renewal
+	authTokenIncrement = 1800
+	// retryInterval is the amount of seconds to wait before retrying a failed login, renewal or rotation
+	retryInterval = 30
+	// expirySlack is the tolerance when comparing expiry times, covering the latency of the vault requests
+	expirySlack = 5 * time.Second
+)
+
+// LoginFunc logs in at vault again, setting the new auth token on the client
+type LoginFunc func() (*api.Secret, error)
+
+// RotateFunc fetches the secrets which are not contained in the given leases again and re-renders the processor
+// output, returning the leases of all rendered secrets
+type RotateFunc func(leases []*Lease) ([]*Lease, error)
+
 // Manager handles leases and cares about automatic renewal of them
 type Manager struct {
 	logger *logrus.Entry
 	client *api.Client
+	policy *retry.Policy
+	login  LoginFunc
+	rotate RotateFunc
+
+	mu           sync.Mutex
+	rotateMu     sync.Mutex
+	leases       []*Lease
+	tokenRenewed time.Time
+	tokenExpiry  time.Time
+
+	// leaseFile is empty unless started by StartRenew
+	leaseFile string
 }
 
-// NewManager returns a new Manager instance
-func NewManager(logger *logrus.Entry, client *api.Client) *Manager {
+// leasesFileMu serializes the writes of the leases files, which are written by the processors as well as the Manager
+var leasesFileMu sync.Mutex
+
+// NewManager returns a new Manager instance. Failed renewals and revocations are retried using the given policy, once
+// the auth token can not be renewed anymore the login func is used to receive a new one. Leases which can not be
+// renewed anymore are rotated using the given rotate func, which may be nil if secrets should not be rotated.
+func NewManager(logger *logrus.Entry, client *api.Client, policy *retry.Policy, login LoginFunc, rotate RotateFunc) *Manager {
 	return &Manager{
 		logger: logger,
 		client: client,
+		policy: policy,
+		login:  login,
+		rotate: rotate,
 	}
 }
 
-// StartRenew kicks of the renew processes - one for the auth token and one per leased secret
+// StartRenew kicks of the renew processes - one for the auth token and one per leased secret. The state of the leases
+// is written back to the leases file after each renewal.
 func (m *Manager) StartRenew(ctx context.Context, leaseFile string) {
+	m.leaseFile = leaseFile
+
 	leases, err := m.loadLeasesFromFile(leaseFile)
 	if err != nil {
 		m.logger.Fatal(err)
@@ -36,15 +81,75 @@ func (m *Manager) StartRenew(ctx context.Context, leaseFile string) {
 		m.logger.Infof("No leases will be renewed as none were found in file %q", leaseFile)
 	}
 
-	go m.renewAuthToken(ctx)
-	go m.renewLeases(ctx, leases)
+	m.Renew(ctx, leases)
+}
 
-	defer m.revokeAuthToken()
-	defer m.revokeLeases(leases)
+// Renew renews the auth token and the given leases until the context is done. Leases which expired already are not
+// renewed but rotated.
+func (m *Manager) Renew(ctx context.Context, leases []*Lease) {
+	go m.renewAuthToken(ctx)
+	m.ManageLeases(ctx, leases)
 
 	<-ctx.Done()
 }
 
+// ManageLeases renews the given leases in the background until the context is done, without renewing the auth token.
+// This allows several managers to share the auth token renewed by another one.
+func (m *Manager) ManageLeases(ctx context.Context, leases []*Lease) {
+	m.mu.Lock()
+	m.leases = leases
+	m.mu.Unlock()
+
+	now := time.Now()
+	var active, expired []*Lease
+	for _, lease := range leases {
+		if lease.leased() && lease.expired(now) {
+			m.logger.Errorf("Lease %q of %v expired at %s, skipping its renewal", lease.LeaseID, lease.Names, lease.ExpiresAt.Format(time.RFC3339))
+			expired = append(expired, lease)
+			continue
+		}
+
+		active = append(active, lease)
+	}
+
+	go m.renewLeases(ctx, active)
+	if len(expired) > 0 {
+		go m.rotateLeases(ctx, expired)
+	}
+}
+
+// Revoke revokes all leases and the auth token afterwards, waiting at most the given timeout for vault to respond
+func (m *Manager) Revoke(timeout time.Duration) {
+	m.wait(timeout, func(ctx context.Context) {
+		// the leases are revoked first, as the revoked auth token could not be used to revoke them anymore
+		m.revokeLeases(ctx)
+		m.revokeAuthToken(ctx)
+	})
+}
+
+// RevokeLeases revokes all leases but keeps the auth token, waiting at most the given timeout for vault to respond
+func (m *Manager) RevokeLeases(timeout time.Duration) {
+	m.wait(timeout, m.revokeLeases)
+}
+
+// wait runs the given func with a context which is done after the given timeout, giving up waiting for it afterwards
+func (m *Manager) wait(timeout time.Duration, fn func(ctx context.Context)) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		m.logger.Errorf("Revocation did not finish within %v, giving up", timeout)
+	}
+}
+
 func (m *Manager) backOff(ctx context.Context, ttl int, handle func()) {
 	t := time.NewTimer(time.Second * time.Duration(ttl))
 
@@ -56,10 +161,42 @@ func (m *Manager) backOff(ctx context.Context, ttl int, handle func()) {
 	}
 }
 
+// renewAuthToken renews the auth token. Once it can not be extended anymore because it is not renewable or reached its
+// max TTL, a new token is fetched by logging in again before the current one expires. Failed renewals are retried
+// until the token is gone.
 func (m *Manager) renewAuthToken(ctx context.Context) {
-	secret, err := m.client.Auth().Token().RenewSelf(1800)
+	m.mu.Lock()
+	previousExpiry := m.tokenExpiry
+	m.mu.Unlock()
+
+	var secret *api.Secret
+	err := m.policy.Do(ctx, "renew auth token", func() error {
+		var err error
+		secret, err = m.client.Auth().Token().RenewSelf(authTokenIncrement)
+		return err
+	})
+	metrics.Renewals.WithLabelValues(metrics.Token, metrics.Result(err)).Inc()
 	if err != nil {
-		m.logger.Errorf("failed to renew auth token from accessor: %v", err)
+		if tokenGone(err, previousExpiry, time.Now()) {
+			m.logger.Errorf("failed to renew auth token, logging in again: %v", err)
+			m.relogin(ctx)
+			return
+		}
+
+		m.logger.Errorf("failed to renew auth token, retrying in %d seconds: %v", retryInterval, err)
+		m.backOff(ctx, retryInterval, func() {
+			m.renewAuthToken(ctx)
+		})
+		return
+	}
+
+	m.tokenUpdated(secret)
+
+	if m.maxTTLReached(secret, previousExpiry) {
+		m.logger.Infof("Auth token reached its max TTL, logging in again in %d seconds", secret.Auth.LeaseDuration*2/3)
+		m.backOff(ctx, secret.Auth.LeaseDuration*2/3, func() {
+			m.relogin(ctx)
+		})
 		return
 	}
 
@@ -70,8 +207,116 @@ func (m *Manager) renewAuthToken(ctx context.Context) {
 	})
 }
 
-func (m *Manager) revokeAuthToken() {
-	err := m.client.Auth().Token().RevokeSelf("")
+// maxTTLReached returns whether the renewed auth token can not be extended anymore. Vault grants less than the
+// requested increment to periodic tokens and to tokens of roles with a short TTL as well, so a shorter TTL alone does
+// not tell the max TTL is reached: it is compared with the remaining explicit max TTL of the token, or, if the token
+// has none, renewing has to stop extending the expiry of the token.
+func (m *Manager) maxTTLReached(secret *api.Secret, previousExpiry time.Time) bool {
+	if !secret.Auth.Renewable {
+		return true
+	}
+	if secret.Auth.LeaseDuration >= authTokenIncrement {
+		return false
+	}
+
+	granted := time.Duration(secret.Auth.LeaseDuration) * time.Second
+	now := time.Now()
+
+	token, err := m.client.Auth().Token().LookupSelf()
+	if err != nil || token == nil {
+		m.logger.Debugf("Unable to look up the auth token, detecting its max TTL by its expiry: %v", err)
+	} else {
+		if durationField(token.Data, "period") > 0 {
+			return false
+		}
+
+		explicitMaxTTL := durationField(token.Data, "explicit_max_ttl")
+		issueTime, _ := token.Data["issue_time"].(string)
+		if issued, err := time.Parse(time.RFC3339Nano, issueTime); err == nil && explicitMaxTTL > 0 {
+			return issued.Add(explicitMaxTTL).Sub(now) <= granted+expirySlack
+		}
+	}
+
+	return !previousExpiry.IsZero() && !now.Add(granted).After(previousExpiry.Add(expirySlack))
+}
+
+// tokenGone returns whether a renewal failing with the given error means the auth token is not valid anymore, either
+// because vault denies it or because it expired already
+func tokenGone(err error, expiry, now time.Time) bool {
+	if code, ok := retry.StatusCode(err); ok && code == http.StatusForbidden {
+		return true
+	}
+
+	return !expiry.IsZero() && !now.Before(expiry)
+}
+
+// durationField returns the duration given in seconds by the field of the given token data, zero if it is missing
+func durationField(data map[string]interface{}, key string) time.Duration {
+	var seconds int64
+	switch v := data[key].(type) {
+	case json.Number:
+		seconds, _ = v.Int64()
+	case float64:
+		seconds = int64(v)
+	case int:
+		seconds = int64(v)
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// relogin fetches a new auth token and rotates all leases, as vault revokes them along with the token which created
+// them once it expires
+func (m *Manager) relogin(ctx context.Context) {
+	if m.login == nil {
+		m.logger.Error("Auth token can not be renewed anymore and no login is configured")
+		return
+	}
+
+	token, err := m.login()
+	if err != nil {
+		m.logger.Errorf("failed to log in again, retrying in %d seconds: %v", retryInterval, err)
+		m.backOff(ctx, retryInterval, func() {
+			m.relogin(ctx)
+		})
+		return
+	}
+
+	m.tokenUpdated(token)
+
+	m.mu.Lock()
+	leases := m.leases
+	m.mu.Unlock()
+
+	if len(leases) > 0 {
+		m.logger.Info("Logged in again, rotating the leases of the previous auth token")
+		m.rotateLeases(ctx, leases)
+	}
+
+	m.backOff(ctx, token.Auth.LeaseDuration/2, func() {
+		m.renewAuthToken(ctx)
+	})
+}
+
+// tokenUpdated records the expiry of the auth token after it was renewed or received by a login
+func (m *Manager) tokenUpdated(secret *api.Secret) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tokenRenewed = now
+	m.tokenExpiry = time.Time{}
+	if secret.Auth.LeaseDuration > 0 {
+		m.tokenExpiry = now.Add(time.Duration(secret.Auth.LeaseDuration) * time.Second)
+	}
+}
+
+func (m *Manager) revokeAuthToken(ctx context.Context) {
+	err := m.policy.Do(ctx, "revoke auth token", func() error {
+		return m.client.Auth().Token().RevokeSelf("")
+	})
+	metrics.Revocations.WithLabelValues(metrics.Token, metrics.Result(err)).Inc()
 	if err != nil {
 		m.logger.Errorf("failed to revoke self token: %v", err)
 		return
@@ -80,57 +325,336 @@ func (m *Manager) revokeAuthToken() {
 	m.logger.Info("Auth token revoked")
 }
 
-func (m *Manager) loadLeasesFromFile(leaseFile string) ([]*api.Secret, error) {
+func (m *Manager) loadLeasesFromFile(leaseFile string) ([]*Lease, error) {
 	m.logger.Debugf("Loading leases from file %s", leaseFile)
 
+	leases, err := LoadLeases(leaseFile)
+	if err != nil {
+		return []*Lease{}, err
+	}
+
+	m.logger.Debugf("Found %d leases in file %s", len(leases), leaseFile)
+
+	return leases, nil
+}
+
+// LoadLeases reads the leases written to the given leases file by the init container
+func LoadLeases(leaseFile string) ([]*Lease, error) {
 	// nolint: gosec
 	content, err := ioutil.ReadFile(leaseFile)
 	if err != nil {
-		return []*api.Secret{}, fmt.Errorf("failed to read written env file: %v", err)
+		return nil, fmt.Errorf("failed to read written env file: %v", err)
 	}
 
-	var leases []*api.Secret
+	var leases []*Lease
 	if err := json.Unmarshal(content, &leases); err != nil {
-		return []*api.Secret{}, fmt.Errorf("failed to unmarshal json leases file: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal json leases file: %v", err)
 	}
 
-	m.logger.Debugf("Found %d leases in file %s", len(leases), leaseFile)
-
 	return leases, nil
 }
 
-func (m *Manager) renewLeases(ctx context.Context, secrets []*api.Secret) {
-	for _, secret := range secrets {
-		go m.renewLease(ctx, secret)
+// SaveLeases writes the given leases to the leases file, replacing it atomically
+func SaveLeases(leaseFile string, leases []*Lease) error {
+	content, err := json.Marshal(leases)
+	if err != nil {
+		return fmt.Errorf("failed to encode leases: %v", err)
 	}
+
+	leasesFileMu.Lock()
+	defer leasesFileMu.Unlock()
+
+	if err := writeFileAtomic(leaseFile, content); err != nil {
+		return fmt.Errorf("failed to write leases file %q: %v", leaseFile, err)
+	}
+
+	return nil
 }
 
-func (m *Manager) renewLease(ctx context.Context, currentSecret *api.Secret) {
-	secret, err := m.client.Sys().Renew(currentSecret.LeaseID, currentSecret.LeaseDuration)
+func (m *Manager) renewLeases(ctx context.Context, leases []*Lease) {
+	now := time.Now()
+
+	for _, lease := range leases {
+		// the lease of a secret rotated at a given time, like the one of a certificate, is not renewed as renewing it
+		// would not extend the validity of the secret
+		if lease.RotateAt != nil {
+			go m.rotateAt(ctx, lease)
+			continue
+		}
+
+		if !lease.leased() {
+			m.logger.Debugf("Skipping secret %q as it is not leased", lease.Path)
+			continue
+		}
+
+		// leases files written before the expiry was stored do not tell when the lease was created, so it is assumed
+		// to be fresh
+		m.mu.Lock()
+		if lease.ExpiresAt == nil {
+			lease.setExpiry(now, lease.LeaseDuration)
+		}
+		m.mu.Unlock()
+
+		go m.renewLease(ctx, lease)
+	}
+}
+
+// renewLease renews the given lease, extending it by its last lease duration. Once the lease is not renewable or
+// vault shortens the renewed lease duration because the max TTL is reached, the lease gets rotated before it expires.
+func (m *Manager) renewLease(ctx context.Context, lease *Lease) {
+	m.mu.Lock()
+	managed := containsLease(m.leases, lease)
+	leaseID, increment, renewable := lease.LeaseID, lease.LeaseDuration, lease.Renewable
+	expiresAt := lease.ExpiresAt
+	m.mu.Unlock()
+
+	if !managed {
+		m.logger.Debugf("Lease %q was rotated, stopping its renewal", leaseID)
+		return
+	}
+
+	if !renewable {
+		// the lease may have been loaded from the leases file, so the remaining TTL is less than its duration
+		delay := increment * 2 / 3
+		if expiresAt != nil {
+			delay = int(time.Until(*expiresAt).Seconds()) * 2 / 3
+		}
+		if delay < 0 {
+			delay = 0
+		}
+
+		m.logger.Infof("Lease %q is not renewable, rotating it in %d seconds", leaseID, delay)
+		m.backOff(ctx, delay, func() {
+			m.rotateLeases(ctx, []*Lease{lease})
+		})
+		return
+	}
+
+	var secret *api.Secret
+	err := m.policy.Do(ctx, fmt.Sprintf("renew lease %q", leaseID), func() error {
+		var err error
+		secret, err = m.client.Sys().Renew(leaseID, increment)
+		return err
+	})
+	metrics.Renewals.WithLabelValues(metrics.Lease, metrics.Result(err)).Inc()
 	if err != nil {
-		m.logger.Errorf("failed to renew lease %q: %v", currentSecret.LeaseID, err)
+		m.logger.Errorf("failed to renew lease %q, rotating it: %v", leaseID, err)
+		m.rotateLeases(ctx, []*Lease{lease})
+		return
+	}
+
+	now := time.Now()
+	m.mu.Lock()
+	lease.LeaseDuration = secret.LeaseDuration
+	lease.Renewable = secret.Renewable
+	lease.LastRenewed = &now
+	lease.setExpiry(now, secret.LeaseDuration)
+	m.mu.Unlock()
+
+	m.saveLeases()
+
+	if secret.LeaseDuration < increment {
+		m.logger.Infof("Lease %q reached its max TTL, rotating it in %d seconds", leaseID, secret.LeaseDuration*2/3)
+		m.backOff(ctx, secret.LeaseDuration*2/3, func() {
+			m.rotateLeases(ctx, []*Lease{lease})
+		})
 		return
 	}
 
-	m.logger.Infof("Lease %q renewed, backing off for %d seconds", secret.LeaseID, secret.LeaseDuration/2)
+	m.logger.Infof("Lease %q renewed, backing off for %d seconds", leaseID, secret.LeaseDuration/2)
 
 	m.backOff(ctx, secret.LeaseDuration/2, func() {
-		m.renewLease(ctx, secret)
+		m.renewLease(ctx, lease)
 	})
 }
 
-func (m *Manager) revokeLeases(secrets []*api.Secret) {
-	for _, secret := range secrets {
-		go m.revokeLease(secret)
+// rotateAt rotates the given lease at the time it is to be rotated
+func (m *Manager) rotateAt(ctx context.Context, lease *Lease) {
+	m.mu.Lock()
+	rotateAt := *lease.RotateAt
+	m.mu.Unlock()
+
+	delay := time.Until(rotateAt)
+	if delay < 0 {
+		delay = 0
 	}
+
+	m.logger.Infof("Rotating secret %q at %s", lease.id(), rotateAt.Format(time.RFC3339))
+	m.backOff(ctx, int(delay.Seconds()), func() {
+		m.rotateLeases(ctx, []*Lease{lease})
+	})
 }
 
-func (m *Manager) revokeLease(secret *api.Secret) {
-	err := m.client.Sys().Revoke(secret.LeaseID)
+// rotateLeases re-reads the secrets of the given leases, re-rendering the processor output and renewing the new leases.
+// The leases replaced by the rotation are revoked once the new output is written.
+func (m *Manager) rotateLeases(ctx context.Context, expiring []*Lease) {
+	if m.rotate == nil {
+		m.logger.Errorf("%d leases can not be renewed anymore and secrets are not rotated", len(expiring))
+		return
+	}
+
+	// rotations are serialized, so each one starts from the leases swapped in by the previous one. The lease mutex
+	// is not held while rotating, as the rotation talks to vault and may take a while.
+	m.rotateMu.Lock()
+	defer m.rotateMu.Unlock()
+
+	m.mu.Lock()
+	var remaining, replaced []*Lease
+	var rotating []string
+	for _, lease := range m.leases {
+		if containsLease(expiring, lease) {
+			rotating = append(rotating, lease.id())
+			replaced = append(replaced, lease)
+		} else {
+			remaining = append(remaining, lease)
+		}
+	}
+	m.mu.Unlock()
+
+	if len(rotating) == 0 {
+		m.logger.Debug("Leases were rotated already")
+		return
+	}
+
+	leases, err := m.rotate(remaining)
 	if err != nil {
-		m.logger.Errorf("failed to renew lease %q: %v", secret.LeaseID, err)
+		m.logger.Errorf("failed to rotate leases %v, retrying in %d seconds: %v", rotating, retryInterval, err)
+		go m.backOff(ctx, retryInterval, func() {
+			m.rotateLeases(ctx, expiring)
+		})
 		return
 	}
 
-	m.logger.Infof("Lease %q revoked", secret.LeaseID)
+	m.mu.Lock()
+	m.leases = leases
+	m.mu.Unlock()
+
+	m.logger.Infof("Secrets rotated, replacing leases %v", rotating)
+	m.saveLeases()
+
+	m.revokeReplaced(ctx, replaced, leases)
+
+	// the remaining leases are renewed already, only the ones new to the manager have to be picked up
+	var added []*Lease
+	for _, lease := range leases {
+		if !containsLease(remaining, lease) {
+			added = append(added, lease)
+		}
+	}
+
+	m.renewLeases(ctx, added)
+}
+
+// revokeReplaced revokes the leases replaced by a rotation, so the credentials do not stay valid until they expire.
+// Leases which expired already or are still in use are skipped.
+func (m *Manager) revokeReplaced(ctx context.Context, replaced, leases []*Lease) {
+	now := time.Now()
+	for _, lease := range replaced {
+		if lease.Secret == nil || lease.LeaseID == "" || lease.expired(now) || containsLease(leases, lease) {
+			continue
+		}
+
+		m.revokeLease(ctx, lease)
+	}
+}
+
+// revokeLeases revokes all leases in parallel, returning once all of them are done
+func (m *Manager) revokeLeases(ctx context.Context) {
+	m.mu.Lock()
+	leases := m.leases
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, lease := range leases {
+		if lease.Secret == nil || lease.LeaseID == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(lease *Lease) {
+			defer wg.Done()
+			m.revokeLease(ctx, lease)
+		}(lease)
+	}
+
+	wg.Wait()
+}
+
+func (m *Manager) revokeLease(ctx context.Context, lease *Lease) {
+	err := m.policy.Do(ctx, fmt.Sprintf("revoke lease %q", lease.LeaseID), func() error {
+		return m.client.Sys().Revoke(lease.LeaseID)
+	})
+	metrics.Revocations.WithLabelValues(metrics.Lease, metrics.Result(err)).Inc()
+	if err != nil {
+		m.logger.Errorf("failed to revoke lease %q: %v", lease.LeaseID, err)
+		return
+	}
+
+	m.logger.Infof("Lease %q revoked", lease.LeaseID)
+}
+
+// saveLeases writes the current state of the leases to the leases file, replacing it atomically
+func (m *Manager) saveLeases() {
+	if m.leaseFile == "" {
+		return
+	}
+
+	leasesFileMu.Lock()
+	defer leasesFileMu.Unlock()
+
+	m.mu.Lock()
+	content, err := json.Marshal(m.leases)
+	m.mu.Unlock()
+	if err != nil {
+		m.logger.Errorf("failed to encode leases: %v", err)
+		return
+	}
+
+	if err := writeFileAtomic(m.leaseFile, content); err != nil {
+		m.logger.Errorf("failed to write leases file %q: %v", m.leaseFile, err)
+		return
+	}
+
+	m.logger.Debugf("Wrote leases to file %s", m.leaseFile)
+}
+
+// writeFileAtomic writes the content into a temporary file next to the given one first, which is renamed afterwards.
+// The mode of an existing file is kept.
+func writeFileAtomic(path string, content []byte) error {
+	mode := os.FileMode(0700)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode()
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(content)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func containsLease(leases []*Lease, lease *Lease) bool {
+	for _, l := range leases {
+		if l == lease {
+			return true
+		}
+	}
+
+	return false
 }
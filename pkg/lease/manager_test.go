@@ -0,0 +1,320 @@
+package lease
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+)
+
+func TestNewLease_Expiry(t *testing.T) {
+	leased := NewLease(&api.Secret{LeaseID: "database/creds/app/abc", LeaseDuration: 60}, "database/creds/app")
+	if leased.ExpiresAt == nil || leased.ExpiresAt.Before(time.Now().Add(59*time.Second)) {
+		t.Errorf("Expected the lease to expire in 60 seconds, got %v", leased.ExpiresAt)
+	}
+
+	static := NewLease(&api.Secret{Data: map[string]interface{}{"key": "value"}}, "secret/app")
+	if static.ExpiresAt != nil {
+		t.Errorf("Expected a secret without lease not to expire, got %v", static.ExpiresAt)
+	}
+}
+
+func TestManager_SaveLeases(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	dir, clean, err := internalTesting.CreateTempDir(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	leaseFile := filepath.Join(dir, "secrets.leases.json")
+	if err := writeFileAtomic(leaseFile, []byte("[]")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(leaseFile, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	lease := NewLease(&api.Secret{LeaseID: "database/creds/app/abc", LeaseDuration: 3600, Renewable: true}, "database/creds/app")
+	lease.AddName("DB")
+	lease.LastRenewed = &renewed
+
+	m := NewManager(logger, nil, nil, nil, nil)
+	m.leaseFile = leaseFile
+	m.leases = []*Lease{lease}
+	m.saveLeases()
+
+	leases, err := m.loadLeasesFromFile(leaseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(leases) != 1 {
+		t.Fatalf("Expected %d lease to be written, got %d", 1, len(leases))
+	}
+	if leases[0].LeaseID != lease.LeaseID || leases[0].Names[0] != "DB" {
+		t.Errorf("Expected lease %q of DB to be written, got %+v", lease.LeaseID, leases[0])
+	}
+	if leases[0].LastRenewed == nil || !leases[0].LastRenewed.Equal(renewed) {
+		t.Errorf("Expected last renewal at %v, got %v", renewed, leases[0].LastRenewed)
+	}
+	if leases[0].ExpiresAt == nil || !leases[0].ExpiresAt.Equal(*lease.ExpiresAt) {
+		t.Errorf("Expected expiry at %v, got %v", lease.ExpiresAt, leases[0].ExpiresAt)
+	}
+
+	info, err := os.Stat(leaseFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0640 {
+		t.Errorf("Expected the file mode %v to be kept, got %v", os.FileMode(0640), info.Mode())
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("Expected no temporary files to be left, got %v", files)
+	}
+}
+
+func TestLease_Expired(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Second)
+	future := now.Add(time.Second)
+
+	tests := []struct {
+		expiresAt *time.Time
+		expired   bool
+	}{
+		{nil, false},
+		{&past, true},
+		{&now, true},
+		{&future, false},
+	}
+
+	for _, test := range tests {
+		l := &Lease{ExpiresAt: test.expiresAt}
+		if expired := l.expired(now); expired != test.expired {
+			t.Errorf("Expected lease expiring at %v to be expired: %v, got %v", test.expiresAt, test.expired, expired)
+		}
+	}
+}
+
+func TestManager_RotateAt(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+
+	rotateAt := time.Now().Add(-time.Minute)
+	cert := &Lease{Secret: &api.Secret{}, Path: "write:pki/issue/app", RotateAt: &rotateAt}
+	reissued := &Lease{Secret: &api.Secret{}, Path: "write:pki/issue/app"}
+
+	rotated := make(chan []*Lease, 1)
+	m := NewManager(logger, nil, nil, nil, func(remaining []*Lease) ([]*Lease, error) {
+		rotated <- remaining
+		return []*Lease{reissued}, nil
+	})
+	m.leases = []*Lease{cert}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.renewLeases(ctx, m.leases)
+
+	select {
+	case remaining := <-rotated:
+		if len(remaining) != 0 {
+			t.Errorf("Expected the certificate not to be reused, got %d remaining leases", len(remaining))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the certificate to be reissued once its rotation time passed")
+	}
+}
+
+func TestManager_RenewLeaseNotRenewable(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+
+	// a lease loaded from the leases file after a restart, which expires long before its full duration passed
+	expiresAt := time.Now().Add(time.Second)
+	loaded := &Lease{Secret: &api.Secret{LeaseID: "database/creds/app/abc", LeaseDuration: 3600}, ExpiresAt: &expiresAt}
+
+	rotated := make(chan []*Lease, 1)
+	m := NewManager(logger, nil, nil, nil, func(remaining []*Lease) ([]*Lease, error) {
+		rotated <- remaining
+		// failing keeps the lease, which would be revoked after a successful rotation
+		return nil, errors.New("rotation failed")
+	})
+	m.leases = []*Lease{loaded}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.renewLease(ctx, loaded)
+
+	select {
+	case <-rotated:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the lease to be rotated before it expires")
+	}
+}
+
+func TestManager_RotateLeases(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+
+	revoked := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(r.URL.Path, "revoke") {
+			revoked <- r.URL.Path + " " + string(body)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiring := NewLease(&api.Secret{LeaseID: "database/creds/app/old", LeaseDuration: 60}, "database/creds/app")
+	static := &Lease{Secret: &api.Secret{Data: map[string]interface{}{"key": "value"}}, Path: "secret/app"}
+	rotated := NewLease(&api.Secret{LeaseID: "database/creds/app/new", LeaseDuration: 60}, "database/creds/app")
+
+	var m *Manager
+	m = NewManager(logger, client, retry.NewPolicy(logger, 1, 0, 0, 0, nil), nil, func(remaining []*Lease) ([]*Lease, error) {
+		// the status is available while rotating, e.g. to be reported by the operator
+		m.Status()
+		return append(remaining, rotated), nil
+	})
+	m.leases = []*Lease{expiring, static}
+
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		defer close(done)
+		m.rotateLeases(ctx, []*Lease{expiring})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the rotation to finish")
+	}
+
+	if len(m.leases) != 2 || m.leases[0] != static || m.leases[1] != rotated {
+		t.Errorf("Expected the expiring lease to be replaced, got %v", m.leases)
+	}
+
+	select {
+	case request := <-revoked:
+		if !strings.Contains(request, "database/creds/app/old") {
+			t.Errorf("Expected the replaced lease to be revoked, got request %q", request)
+		}
+	default:
+		t.Error("Expected the replaced lease to be revoked")
+	}
+	if len(revoked) != 0 {
+		t.Errorf("Expected only the replaced lease to be revoked, got %d more revocations", len(revoked))
+	}
+}
+
+func TestManager_MaxTTLReached(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	now := time.Now()
+
+	var lookup map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/auth/token/lookup-self") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": lookup})
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(logger, client, retry.NewPolicy(logger, 1, 0, 0, 0, nil), nil, nil)
+
+	tests := []struct {
+		name           string
+		renewable      bool
+		ttl            int
+		lookup         map[string]interface{}
+		previousExpiry time.Time
+		reached        bool
+	}{
+		{name: "not renewable", renewable: false, ttl: 3600, reached: true},
+		{name: "full increment", renewable: true, ttl: authTokenIncrement},
+		{
+			name: "periodic", renewable: true, ttl: 600,
+			lookup:         map[string]interface{}{"period": 600},
+			previousExpiry: now.Add(600 * time.Second),
+		},
+		{
+			name: "explicit max ttl reached", renewable: true, ttl: 600,
+			lookup:  map[string]interface{}{"explicit_max_ttl": 4200, "issue_time": now.Add(-time.Hour).Format(time.RFC3339Nano)},
+			reached: true,
+		},
+		{
+			name: "short ttl below explicit max ttl", renewable: true, ttl: 600,
+			lookup: map[string]interface{}{"explicit_max_ttl": 7200, "issue_time": now.Format(time.RFC3339Nano)},
+		},
+		{
+			name: "short ttl extending the expiry", renewable: true, ttl: 600,
+			lookup:         map[string]interface{}{},
+			previousExpiry: now.Add(300 * time.Second),
+		},
+		{
+			name: "expiry not extended", renewable: true, ttl: 600,
+			lookup:         map[string]interface{}{},
+			previousExpiry: now.Add(600 * time.Second),
+			reached:        true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lookup = test.lookup
+			secret := &api.Secret{Auth: &api.SecretAuth{Renewable: test.renewable, LeaseDuration: test.ttl}}
+			if reached := m.maxTTLReached(secret, test.previousExpiry); reached != test.reached {
+				t.Errorf("Expected max TTL reached to be %v, got %v", test.reached, reached)
+			}
+		})
+	}
+}
+
+func TestTokenGone(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		err    error
+		expiry time.Time
+		gone   bool
+	}{
+		{name: "permission denied", err: &api.ResponseError{StatusCode: http.StatusForbidden}, expiry: now.Add(time.Hour), gone: true},
+		{name: "server error", err: &api.ResponseError{StatusCode: http.StatusInternalServerError}, expiry: now.Add(time.Hour)},
+		{name: "network error", err: errors.New("connection refused"), expiry: now.Add(time.Hour)},
+		{name: "expired", err: errors.New("connection refused"), expiry: now.Add(-time.Second), gone: true},
+	}
+
+	for _, test := range tests {
+		if gone := tokenGone(test.err, test.expiry, now); gone != test.gone {
+			t.Errorf("%s: expected token gone to be %v, got %v", test.name, test.gone, gone)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package lease
+
+import (
+	"time"
+)
+
+// Status describes the renewal state of the auth token and the leases
+type Status struct {
+	Ready  bool          `json:"ready"`
+	Token  TokenStatus   `json:"token"`
+	Leases []LeaseStatus `json:"leases"`
+}
+
+// TokenStatus describes the renewal state of the auth token
+type TokenStatus struct {
+	// TTL is the amount of seconds until the token expires, zero if it expired already or does not expire
+	TTL         int        `json:"ttl"`
+	LastRenewed *time.Time `json:"last_renewed"`
+}
+
+// LeaseStatus describes the renewal state of a single lease
+type LeaseStatus struct {
+	LeaseID string   `json:"lease_id"`
+	Names   []string `json:"names,omitempty"`
+	// TTL is the amount of seconds until the lease expires
+	TTL         int        `json:"ttl"`
+	LastRenewed *time.Time `json:"last_renewed"`
+}
+
+// Status returns the current renewal state
+func (m *Manager) Status() Status {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	status := Status{
+		Ready:  m.ready(now),
+		Token:  TokenStatus{TTL: remainingSeconds(now, m.tokenExpiry)},
+		Leases: []LeaseStatus{},
+	}
+	if !m.tokenRenewed.IsZero() {
+		renewed := m.tokenRenewed
+		status.Token.LastRenewed = &renewed
+	}
+
+	for _, lease := range m.leases {
+		if !lease.leased() {
+			continue
+		}
+
+		leaseStatus := LeaseStatus{
+			LeaseID:     lease.LeaseID,
+			Names:       lease.Names,
+			LastRenewed: lease.LastRenewed,
+		}
+		if lease.ExpiresAt != nil {
+			leaseStatus.TTL = remainingSeconds(now, *lease.ExpiresAt)
+		}
+
+		status.Leases = append(status.Leases, leaseStatus)
+	}
+
+	return status
+}
+
+// Ready returns whether the auth token is valid and all leases were renewed within their TTL
+func (m *Manager) Ready() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ready(time.Now())
+}
+
+func (m *Manager) ready(now time.Time) bool {
+	if m.tokenRenewed.IsZero() || (!m.tokenExpiry.IsZero() && !now.Before(m.tokenExpiry)) {
+		return false
+	}
+
+	for _, lease := range m.leases {
+		if lease.leased() && (lease.ExpiresAt == nil || lease.expired(now)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func remainingSeconds(now, expiry time.Time) int {
+	if expiry.IsZero() || !now.Before(expiry) {
+		return 0
+	}
+
+	return int(expiry.Sub(now).Seconds())
+}
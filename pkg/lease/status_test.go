@@ -0,0 +1,49 @@
+package lease
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+)
+
+func TestManager_Ready(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Second)
+	future := now.Add(time.Minute)
+
+	leased := func(expiresAt *time.Time) *Lease {
+		return &Lease{Secret: &api.Secret{LeaseID: "database/creds/app/abc", LeaseDuration: 60}, ExpiresAt: expiresAt}
+	}
+	static := &Lease{Secret: &api.Secret{Data: map[string]interface{}{"key": "value"}}, Path: "secret/app"}
+	rotated := &Lease{Secret: &api.Secret{}, Path: "write:pki/issue/app", RotateAt: &past}
+
+	tests := []struct {
+		name         string
+		tokenRenewed time.Time
+		tokenExpiry  time.Time
+		leases       []*Lease
+		ready        bool
+	}{
+		{"valid", past, future, []*Lease{leased(&future)}, true},
+		{"token not renewed yet", time.Time{}, future, []*Lease{leased(&future)}, false},
+		{"expired token", past, past, []*Lease{leased(&future)}, false},
+		{"token expiring now", past, now, nil, false},
+		{"token without expiry", past, time.Time{}, nil, true},
+		{"overdue lease", past, future, []*Lease{leased(&future), leased(&past)}, false},
+		{"lease of unknown expiry", past, future, []*Lease{leased(nil)}, false},
+		{"non-leased secrets", past, future, []*Lease{static, rotated}, true},
+		{"non-leased and overdue secrets", past, future, []*Lease{static, leased(&past)}, false},
+	}
+
+	for _, test := range tests {
+		m := NewManager(nil, nil, nil, nil, nil)
+		m.tokenRenewed = test.tokenRenewed
+		m.tokenExpiry = test.tokenExpiry
+		m.leases = test.leases
+
+		if ready := m.ready(now); ready != test.ready {
+			t.Errorf("%s: Expected ready to be %v, got %v", test.name, test.ready, ready)
+		}
+	}
+}
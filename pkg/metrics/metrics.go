@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "kube_vault"
+
+// result label values
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// type label values of renewals and revocations
+const (
+	Token = "token"
+	Lease = "lease"
+)
+
+var (
+	// LoginAttempts counts the login requests sent to vault
+	LoginAttempts = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_attempts_total",
+		Help:      "Number of login requests sent to vault.",
+	})
+
+	// LoginFailures counts the failed login requests
+	LoginFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Number of failed login requests.",
+	})
+
+	// ReadDuration observes the time it takes to read a secret, by SECRET_ name
+	ReadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "secret_read_duration_seconds",
+		Help:      "Time it takes to read a secret from vault.",
+	}, []string{"name"})
+
+	// ReadErrors counts the failed secret reads, by SECRET_ name
+	ReadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "secret_read_errors_total",
+		Help:      "Number of failed secret reads.",
+	}, []string{"name"})
+
+	// Renewals counts the renewals of the auth token and the leases, by type and result
+	Renewals = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "renewals_total",
+		Help:      "Number of auth token and lease renewals.",
+	}, []string{"type", "result"})
+
+	// Revocations counts the revocations of the auth token and the leases, by type and result
+	Revocations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revocations_total",
+		Help:      "Number of auth token and lease revocations.",
+	}, []string{"type", "result"})
+)
+
+func init() {
+	prometheus.MustRegister(LoginAttempts, LoginFailures, ReadDuration, ReadErrors, Renewals, Revocations)
+}
+
+// Result returns the result label value of the given error
+func Result(err error) string {
+	if err != nil {
+		return Failure
+	}
+
+	return Success
+}
@@ -0,0 +1,34 @@
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Command runs a shell command
+type Command struct {
+	command string
+}
+
+// NewCommand returns a new Command instance
+func NewCommand(command string) *Command {
+	return &Command{
+		command: command,
+	}
+}
+
+func (c *Command) String() string {
+	return fmt.Sprintf("command %q", c.command)
+}
+
+// Notify runs the command using sh, failing if it exits with a non zero exit code
+func (c *Command) Notify() error {
+	// nolint: gosec
+	out, err := exec.Command("/bin/sh", "-c", c.command).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
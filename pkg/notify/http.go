@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// HTTP posts to a local url of the app container, e.g. a reload endpoint
+type HTTP struct {
+	url    string
+	client *http.Client
+}
+
+// NewHTTP returns a new HTTP instance
+func NewHTTP(url string) *HTTP {
+	return &HTTP{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (h *HTTP) String() string {
+	return fmt.Sprintf("url %s", h.url)
+}
+
+// Notify posts an empty request to the url, expecting a 2xx status code
+func (h *HTTP) Notify() error {
+	resp, err := h.client.Post(h.url, "text/plain", nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
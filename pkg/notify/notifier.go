@@ -0,0 +1,60 @@
+package notify
+
+import (
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Notifier tells the application that its secrets were re-rendered
+type Notifier interface {
+	// String returns a short human readable description of the notifier, used for logging
+	String() string
+	// Notify sends the notification
+	Notify() error
+}
+
+// Hooks runs a set of notifiers, retrying the failed ones
+type Hooks struct {
+	logger    *logrus.Entry
+	notifiers []Notifier
+	attempts  int
+	interval  time.Duration
+}
+
+// NewHooks returns a new Hooks instance. Each notifier is tried up to the given amount of attempts, waiting the given
+// interval in between.
+func NewHooks(logger *logrus.Entry, attempts int, interval time.Duration, notifiers ...Notifier) *Hooks {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	return &Hooks{
+		logger:    logger,
+		notifiers: notifiers,
+		attempts:  attempts,
+		interval:  interval,
+	}
+}
+
+// Run sends all notifications, blocking until all of them succeeded or ran out of attempts
+func (h *Hooks) Run() {
+	for _, notifier := range h.notifiers {
+		h.notify(notifier)
+	}
+}
+
+func (h *Hooks) notify(notifier Notifier) {
+	for attempt := 1; attempt <= h.attempts; attempt++ {
+		err := notifier.Notify()
+		if err == nil {
+			h.logger.Infof("Notified %s", notifier)
+			return
+		}
+
+		h.logger.Errorf("failed to notify %s (attempt %d of %d): %v", notifier, attempt, h.attempts, err)
+		if attempt < h.attempts {
+			time.Sleep(h.interval)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+type failingNotifier struct {
+	failures int
+	calls    int
+}
+
+func (n *failingNotifier) String() string {
+	return "failing notifier"
+}
+
+func (n *failingNotifier) Notify() error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("failed")
+	}
+
+	return nil
+}
+
+func TestHooks_RunRetries(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	recovering := &failingNotifier{failures: 2}
+	broken := &failingNotifier{failures: 10}
+
+	NewHooks(logger, 3, 0, recovering, broken).Run()
+
+	if recovering.calls != 3 {
+		t.Errorf("Expected the recovering notifier to be called %d times, got %d", 3, recovering.calls)
+	}
+	if broken.calls != 3 {
+		t.Errorf("Expected the broken notifier to be called %d times, got %d", 3, broken.calls)
+	}
+}
+
+func TestHTTP_Notify(t *testing.T) {
+	var method string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	if err := NewHTTP(server.URL).Notify(); err != nil {
+		t.Errorf("Got unexpected error from Notify(): %v", err)
+	}
+	if method != http.MethodPost {
+		t.Errorf("Expected a %s request, got %s", http.MethodPost, method)
+	}
+
+	status = http.StatusInternalServerError
+	if err := NewHTTP(server.URL).Notify(); err == nil {
+		t.Errorf("Expected an error for status code %d, got none", status)
+	}
+}
+
+func TestCommand_Notify(t *testing.T) {
+	if err := NewCommand("exit 0").Notify(); err != nil {
+		t.Errorf("Got unexpected error from Notify(): %v", err)
+	}
+	if err := NewCommand("echo failed && exit 1").Notify(); err == nil {
+		t.Errorf("Expected an error for a failing command, got none")
+	}
+}
@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+var signals = map[string]syscall.Signal{
+	"SIGHUP":  syscall.SIGHUP,
+	"SIGINT":  syscall.SIGINT,
+	"SIGQUIT": syscall.SIGQUIT,
+	"SIGTERM": syscall.SIGTERM,
+	"SIGUSR1": syscall.SIGUSR1,
+	"SIGUSR2": syscall.SIGUSR2,
+}
+
+// ParseSignal returns the signal of the given name, e.g. "SIGHUP" or "HUP"
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	sig, ok := signals[name]
+	if !ok {
+		return 0, fmt.Errorf("unsupported signal %q", name)
+	}
+
+	return sig, nil
+}
+
+// Signal sends a signal to a process of the app container, which is found by its name or a pid file. This requires
+// the pod to share the process namespace between its containers.
+type Signal struct {
+	signal      syscall.Signal
+	processName string
+	pidFile     string
+	procDir     string
+}
+
+// NewSignal returns a new Signal instance. If pidFile is not empty the pid is read from that file, otherwise the
+// process is looked up by its name.
+func NewSignal(signal syscall.Signal, processName, pidFile string) *Signal {
+	return &Signal{
+		signal:      signal,
+		processName: processName,
+		pidFile:     pidFile,
+		procDir:     "/proc",
+	}
+}
+
+func (s *Signal) String() string {
+	if s.pidFile != "" {
+		return fmt.Sprintf("process of pid file %s using %v", s.pidFile, s.signal)
+	}
+
+	return fmt.Sprintf("process %s using %v", s.processName, s.signal)
+}
+
+// Notify sends the signal to the process
+func (s *Signal) Notify() error {
+	pid, err := s.pid()
+	if err != nil {
+		return err
+	}
+
+	return syscall.Kill(pid, s.signal)
+}
+
+func (s *Signal) pid() (int, error) {
+	if s.pidFile == "" {
+		return findProcess(s.procDir, s.processName)
+	}
+
+	// nolint: gosec
+	b, err := ioutil.ReadFile(s.pidFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pid file: %v", err)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid pid in pid file %q: %v", s.pidFile, err)
+	}
+
+	return pid, nil
+}
+
+// findProcess returns the pid of the first process in procDir whose executable or command name matches name
+func findProcess(procDir, name string) (int, error) {
+	entries, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list processes: %v", err)
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() || pid == os.Getpid() {
+			continue
+		}
+
+		// nolint: gosec
+		cmdline, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err == nil && len(cmdline) > 0 {
+			if filepath.Base(strings.SplitN(string(cmdline), "\x00", 2)[0]) == name {
+				return pid, nil
+			}
+		}
+
+		// nolint: gosec
+		comm, err := ioutil.ReadFile(filepath.Join(procDir, entry.Name(), "comm"))
+		if err == nil && strings.TrimSpace(string(comm)) == name {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no process named %q found, is the process namespace shared?", name)
+}
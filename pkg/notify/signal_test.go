@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	for name, exp := range map[string]syscall.Signal{"SIGHUP": syscall.SIGHUP, "hup": syscall.SIGHUP, "USR1": syscall.SIGUSR1} {
+		sig, err := ParseSignal(name)
+		if err != nil {
+			t.Errorf("Got unexpected error for %q: %v", name, err)
+		}
+		if sig != exp {
+			t.Errorf("Expected to get %v for %q, got %v", exp, name, sig)
+		}
+	}
+
+	if _, err := ParseSignal("SIGKILLALL"); err == nil {
+		t.Errorf("Expected an error for an unknown signal, got none")
+	}
+}
+
+func TestFindProcess(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	procDir, cleanup, err := internalTesting.CreateTempDir(logger)
+	if err != nil {
+		t.Fatalf("failed to create proc dir: %v", err)
+	}
+	defer cleanup()
+
+	processes := map[string]map[string]string{
+		"1":  {"cmdline": "/pause\x00", "comm": "pause\n"},
+		"12": {"cmdline": "/usr/sbin/nginx\x00-g\x00daemon off;\x00", "comm": "nginx\n"},
+		"13": {"comm": "java\n"},
+	}
+	for pid, files := range processes {
+		if err := os.Mkdir(filepath.Join(procDir, pid), 0755); err != nil {
+			t.Fatalf("failed to create process dir: %v", err)
+		}
+		for name, content := range files {
+			if err := ioutil.WriteFile(filepath.Join(procDir, pid, name), []byte(content), 0644); err != nil {
+				t.Fatalf("failed to write process file: %v", err)
+			}
+		}
+	}
+
+	for name, exp := range map[string]int{"nginx": 12, "java": 13} {
+		pid, err := findProcess(procDir, name)
+		if err != nil {
+			t.Errorf("Got unexpected error for %q: %v", name, err)
+		}
+		if pid != exp {
+			t.Errorf("Expected to get pid %d for %q, got %d", exp, name, pid)
+		}
+	}
+
+	if _, err := findProcess(procDir, "ruby"); err == nil {
+		t.Errorf("Expected an error for a missing process, got none")
+	}
+}
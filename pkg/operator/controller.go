@@ -0,0 +1,482 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+const (
+	// resyncPeriod is the interval all VaultSecrets are checked for changes in, in addition to watching them
+	resyncPeriod = 10 * time.Minute
+	// statusInterval is the interval the lease state is reported in the status of the VaultSecrets in
+	statusInterval = time.Minute
+	// revokeTimeout is the time to wait for vault to revoke the leases of a deleted VaultSecret
+	revokeTimeout = 10 * time.Second
+)
+
+type vaultLogicalClient interface {
+	Read(path string) (*api.Secret, error)
+	ReadWithData(path string, data map[string][]string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+// Controller keeps the kubernetes secrets declared by VaultSecret resources in sync with vault. The leases of each
+// VaultSecret are renewed by a lease manager of its own, sharing the auth token of the controller.
+type Controller struct {
+	logger     *logrus.Entry
+	client     dynamic.Interface
+	kubeClient kubernetes.Interface
+	vault      *api.Client
+	logical    vaultLogicalClient
+	policy     *retry.Policy
+	format     processor.ValueFormat
+	prefixes   PathPrefixes
+	queue      workqueue.RateLimitingInterface
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// entry is a VaultSecret being synced, keyed by its namespace and name
+type entry struct {
+	generation int64
+	target     string
+	cancel     context.CancelFunc
+	processor  processor.Processor
+	manager    *lease.Manager
+
+	// mu serializes the refreshes of the rotated leases and the refresh interval
+	mu     sync.Mutex
+	leases []*lease.Lease
+	err    error
+	synced time.Time
+}
+
+// NewController returns a new Controller instance. The VaultSecrets are watched using the given dynamic client, while
+// the kubernetes secrets are written using the kubernetes client. Secrets are read using the given logical client
+// and leases are renewed using the vault client. The VaultSecrets of a namespace may only read the paths of its
+// prefixes.
+func NewController(logger *logrus.Entry, client dynamic.Interface, kubeClient kubernetes.Interface, vault *api.Client, logical vaultLogicalClient, policy *retry.Policy, format processor.ValueFormat, prefixes PathPrefixes) *Controller {
+	return &Controller{
+		logger:     logger,
+		client:     client,
+		kubeClient: kubeClient,
+		vault:      vault,
+		logical:    logical,
+		policy:     policy,
+		format:     format,
+		prefixes:   prefixes,
+		queue:      workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "vaultsecrets"),
+		entries:    map[string]*entry{},
+	}
+}
+
+// Run watches the VaultSecrets of the given namespace, all namespaces if empty, and syncs them using the given number
+// of workers until the context is done. Leases are not revoked on shutdown, as the kubernetes secrets still contain
+// them. Watching all namespaces requires path prefixes per namespace.
+func (c *Controller) Run(ctx context.Context, namespace string, workers int) error {
+	defer c.queue.ShutDown()
+
+	if namespace == "" && len(c.prefixes) == 0 {
+		return errors.New("refusing to watch all namespaces without path prefixes per namespace")
+	}
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(c.client, Resource, namespace, resyncPeriod, cache.Indexers{}, nil).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    c.enqueue,
+		UpdateFunc: func(_, obj interface{}) { c.enqueue(obj) },
+		DeleteFunc: c.enqueue,
+	})
+
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return errors.New("failed to list the VaultSecrets")
+	}
+
+	c.logger.Infof("Watching VaultSecrets using %d workers", workers)
+	for i := 0; i < workers; i++ {
+		go c.work()
+	}
+
+	<-ctx.Done()
+
+	c.mu.Lock()
+	for _, e := range c.entries {
+		e.stop(false)
+	}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Reset syncs all VaultSecrets again, reading all of their secrets. It is used after logging in again, as vault
+// revokes the leases created by the previous auth token once it expires.
+func (c *Controller) Reset() {
+	c.mu.Lock()
+	entries := c.entries
+	c.entries = map[string]*entry{}
+	c.mu.Unlock()
+
+	for key, e := range entries {
+		e.stop(false)
+		c.queue.Add(key)
+	}
+}
+
+func (c *Controller) enqueue(obj interface{}) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		c.logger.Errorf("failed to get the key of %s: %v", Kind, err)
+		return
+	}
+
+	c.queue.Add(key)
+}
+
+func (c *Controller) work() {
+	for c.next() {
+	}
+}
+
+// next syncs the next VaultSecret of the queue, returning false once the queue is shut down
+func (c *Controller) next() bool {
+	key, shutdown := c.queue.Get()
+	if shutdown {
+		return false
+	}
+	defer c.queue.Done(key)
+
+	if err := c.sync(key.(string)); err != nil {
+		c.logger.Errorf("failed to sync %s %s, retrying: %v", Kind, key, err)
+		c.queue.AddRateLimited(key)
+		return true
+	}
+
+	c.queue.Forget(key)
+	return true
+}
+
+// sync starts syncing the VaultSecret of the given key, unless its spec is synced already. The leases of a previous
+// spec or a deleted VaultSecret are revoked.
+func (c *Controller) sync(key string) error {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return err
+	}
+
+	obj, err := c.client.Resource(Resource).Namespace(namespace).Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		c.remove(key)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	vaultSecret, err := fromUnstructured(obj)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	existing := c.entries[key]
+	c.mu.Unlock()
+
+	if existing != nil && existing.generation == vaultSecret.Generation {
+		return nil
+	}
+
+	e, err := c.start(key, vaultSecret)
+	if err != nil {
+		c.updateStatus(key, &entry{generation: vaultSecret.Generation, err: err})
+		return err
+	}
+
+	c.mu.Lock()
+	c.entries[key] = e
+	c.mu.Unlock()
+
+	// the kubernetes secret does not contain the leases of the previous spec anymore
+	if existing != nil {
+		existing.stop(true)
+	}
+
+	previousTarget := vaultSecret.Status.Target
+	if existing != nil {
+		previousTarget = existing.target
+	}
+	if previousTarget != "" && previousTarget != e.target {
+		c.deleteTarget(namespace, previousTarget, vaultSecret.UID)
+	}
+
+	c.logger.Infof("Synced %s %s", Kind, key)
+	c.updateStatus(key, e)
+
+	return nil
+}
+
+// start writes the kubernetes secret of the given VaultSecret and starts renewing its leases
+func (c *Controller) start(key string, vaultSecret *VaultSecret) (*entry, error) {
+	if vaultSecret.Spec.Target.Name == "" {
+		return nil, errors.New("no target name given")
+	}
+
+	if err := c.prefixes.check(vaultSecret.Namespace, vaultSecret.Spec.Secrets); err != nil {
+		return nil, err
+	}
+
+	interval, err := vaultSecret.Spec.refreshInterval()
+	if err != nil {
+		return nil, err
+	}
+
+	logger := c.logger.WithField("vaultsecret", key)
+
+	controller := true
+	owner := &metav1.OwnerReference{
+		APIVersion: Group + "/" + Version,
+		Kind:       Kind,
+		Name:       vaultSecret.Name,
+		UID:        vaultSecret.UID,
+		Controller: &controller,
+	}
+	target := vaultSecret.Spec.Target
+	output := processor.NewKubernetesSecret(logger, nil, c.kubeClient, vaultSecret.Namespace, target.Name, target.Labels, owner, c.format, "")
+
+	e := &entry{
+		generation: vaultSecret.Generation,
+		target:     target.Name,
+		processor:  processor.NewMulti(logger, vaultSecret.Spec.Secrets, nil, []processor.Output{output}, c.format, ""),
+	}
+
+	if _, err := e.refresh(c.logical, nil); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e.cancel = cancel
+	e.manager = lease.NewManager(logger, c.vault, c.policy, nil, func(remaining []*lease.Lease) ([]*lease.Lease, error) {
+		leases, err := e.refresh(c.logical, remaining)
+		c.updateStatus(key, e)
+		return leases, err
+	})
+
+	e.manager.ManageLeases(ctx, e.leases)
+	go c.watch(ctx, key, e, interval)
+
+	return e, nil
+}
+
+// watch refreshes the static secrets of the given entry in the given interval, if any, and reports its lease state
+// until the context is done
+func (c *Controller) watch(ctx context.Context, key string, e *entry, interval time.Duration) {
+	status := time.NewTicker(statusInterval)
+	defer status.Stop()
+
+	var refresh <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		refresh = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-refresh:
+			if err := e.refreshCurrent(c.logical); err != nil {
+				c.logger.Errorf("failed to refresh %s %s: %v", Kind, key, err)
+			}
+		case <-status.C:
+		}
+
+		c.updateStatus(key, e)
+	}
+}
+
+// remove stops syncing the VaultSecret of the given key, revoking its leases
+func (c *Controller) remove(key string) {
+	c.mu.Lock()
+	e := c.entries[key]
+	delete(c.entries, key)
+	c.mu.Unlock()
+
+	if e != nil {
+		c.logger.Infof("%s %s was deleted, revoking its leases", Kind, key)
+		e.stop(true)
+	}
+}
+
+// deleteTarget deletes the kubernetes secret the VaultSecret with the given uid wrote before its target was renamed.
+// Secrets not controlled by the VaultSecret are kept.
+func (c *Controller) deleteTarget(namespace, name string, owner types.UID) {
+	secrets := c.kubeClient.CoreV1().Secrets(namespace)
+	secret, err := secrets.Get(name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return
+	}
+	if err != nil {
+		c.logger.Errorf("failed to get the previous target secret %s/%s: %v", namespace, name, err)
+		return
+	}
+
+	if controller := metav1.GetControllerOf(secret); controller == nil || controller.UID != owner {
+		c.logger.Warnf("Keeping the previous target secret %s/%s, as it is not controlled by the %s", namespace, name, Kind)
+		return
+	}
+
+	uid := secret.UID
+	if err := secrets.Delete(name, &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &uid}}); err != nil && !apierrors.IsNotFound(err) {
+		c.logger.Errorf("failed to delete the previous target secret %s/%s: %v", namespace, name, err)
+		return
+	}
+
+	c.logger.Infof("Deleted the previous target secret %s/%s", namespace, name)
+}
+
+// updateStatus reports the sync and lease state of the given entry in the status of its VaultSecret, if it changed
+func (c *Controller) updateStatus(key string, e *entry) {
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return
+	}
+
+	resource := c.client.Resource(Resource).Namespace(namespace)
+	obj, err := resource.Get(name, metav1.GetOptions{})
+	if err != nil {
+		if !apierrors.IsNotFound(err) {
+			c.logger.Errorf("failed to get %s %s to update its status: %v", Kind, key, err)
+		}
+		return
+	}
+
+	vaultSecret, err := fromUnstructured(obj)
+	if err != nil {
+		c.logger.Error(err)
+		return
+	}
+
+	status := vaultSecret.Status
+	e.reportStatus(&status, metav1.Now())
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		c.logger.Errorf("failed to convert status of %s %s: %v", Kind, key, err)
+		return
+	}
+	// the status is reported every statusInterval, but only written if it changed
+	if reflect.DeepEqual(obj.Object["status"], content) {
+		return
+	}
+	obj.Object["status"] = content
+
+	if _, err := resource.UpdateStatus(obj, metav1.UpdateOptions{}); err != nil {
+		c.logger.Errorf("failed to update status of %s %s: %v", Kind, key, err)
+	}
+}
+
+// refresh renders the kubernetes secret again, fetching only the secrets not contained in the given leases
+func (e *entry) refresh(logical vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.refreshLocked(logical, leases)
+}
+
+// refreshCurrent renders the kubernetes secret again, reading the static secrets while reusing the current leases
+func (e *entry) refreshCurrent(logical vaultLogicalClient) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err := e.refreshLocked(logical, e.leases)
+	return err
+}
+
+func (e *entry) refreshLocked(logical vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refreshed, err := e.processor.Refresh(logical, leases)
+	e.err = err
+	if err != nil {
+		return nil, err
+	}
+
+	e.leases = refreshed
+	e.synced = time.Now()
+
+	return refreshed, nil
+}
+
+// reportStatus sets the conditions and lease state of the entry on the given status
+func (e *entry) reportStatus(status *VaultSecretStatus, now metav1.Time) {
+	e.mu.Lock()
+	err, synced := e.err, e.synced
+	e.mu.Unlock()
+
+	status.ObservedGeneration = e.generation
+	if e.target != "" {
+		status.Target = e.target
+	}
+	if !synced.IsZero() {
+		lastSynced := metav1.NewTime(synced)
+		status.LastSynced = &lastSynced
+	}
+
+	ready, reason, message := "True", "Synced", ""
+	if err != nil {
+		ready, reason, message = "False", "SyncFailed", err.Error()
+		status.setCondition(ConditionSynced, "False", reason, message, now)
+	} else {
+		status.setCondition(ConditionSynced, "True", reason, "", now)
+	}
+
+	status.Leases = nil
+	var expired []string
+	if e.manager != nil {
+		for _, l := range e.manager.Status().Leases {
+			status.Leases = append(status.Leases, LeaseStatus{LeaseID: l.LeaseID, Names: l.Names})
+			if l.TTL == 0 {
+				expired = append(expired, l.LeaseID)
+			}
+		}
+	}
+
+	if len(expired) > 0 {
+		expiredMessage := fmt.Sprintf("leases %v expired", expired)
+		status.setCondition(ConditionLeasesValid, "False", "LeaseExpired", expiredMessage, now)
+		if err == nil {
+			ready, reason, message = "False", "LeaseExpired", expiredMessage
+		}
+	} else {
+		status.setCondition(ConditionLeasesValid, "True", "Renewing", fmt.Sprintf("%d leases renewed", len(status.Leases)), now)
+	}
+
+	status.setCondition(ConditionReady, ready, reason, message, now)
+}
+
+// stop stops the renewal of the leases, revoking them if requested
+func (e *entry) stop(revoke bool) {
+	e.cancel()
+
+	if revoke {
+		e.manager.RevokeLeases(revokeTimeout)
+	}
+}
@@ -0,0 +1,394 @@
+package operator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicFake "k8s.io/client-go/dynamic/fake"
+	kubeFake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newVaultSecret(generation int64, secrets ...interface{}) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": Group + "/" + Version,
+		"kind":       Kind,
+		"metadata": map[string]interface{}{
+			"name":       "app",
+			"namespace":  "default",
+			"uid":        "1234",
+			"generation": generation,
+		},
+		"spec": map[string]interface{}{
+			"secrets": secrets,
+			"target": map[string]interface{}{
+				"name":   "app-secrets",
+				"labels": map[string]interface{}{"app": "test"},
+			},
+		},
+	}}
+}
+
+func TestController_Sync(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	logical := internalTesting.NewVaultClientLogical(nil, nil)
+	logical.PathResults = map[string]*api.Secret{
+		"secret/db":  {Data: map[string]interface{}{"username": "test1234", "password": "test5678"}},
+		"secret/api": {Data: map[string]interface{}{"key": "abcd"}},
+	}
+
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), newVaultSecret(1, map[string]interface{}{
+		"name":   "DB",
+		"path":   "secret/db",
+		"rename": map[string]interface{}{"username": "user"},
+	}))
+	kubeClient := kubeFake.NewSimpleClientset()
+	policy := retry.NewPolicy(logger, 1, 0, 0, 0, nil)
+
+	c := NewController(logger, client, kubeClient, nil, logical, policy, processor.ValueFormat{}, nil)
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the kubernetes secret to be created, got %v", err)
+	}
+
+	exp := map[string][]byte{"DB_USER": []byte("test1234"), "DB_PASSWORD": []byte("test5678")}
+	if !reflect.DeepEqual(exp, secret.Data) {
+		t.Errorf("Expected to get data %s, got %s", exp, secret.Data)
+	}
+	if len(secret.OwnerReferences) != 1 || secret.OwnerReferences[0].Kind != Kind || secret.OwnerReferences[0].UID != "1234" {
+		t.Errorf("Expected the kubernetes secret to be owned by the %s, got %v", Kind, secret.OwnerReferences)
+	}
+	assertCondition(t, client, ConditionReady, "True")
+	assertCondition(t, client, ConditionLeasesValid, "True")
+
+	// a changed spec is synced again
+	if _, err := client.Resource(Resource).Namespace("default").Update(newVaultSecret(2, map[string]interface{}{
+		"name": "API",
+		"path": "secret/api",
+	}), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update %s: %v", Kind, err)
+	}
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+
+	secret, err = kubeClient.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get kubernetes secret: %v", err)
+	}
+	if exp := map[string][]byte{"API_KEY": []byte("abcd")}; !reflect.DeepEqual(exp, secret.Data) {
+		t.Errorf("Expected to get data %s after the update, got %s", exp, secret.Data)
+	}
+
+	// failures are reported in the status
+	logical.ResultError = errors.New("permission denied")
+	if _, err := client.Resource(Resource).Namespace("default").Update(newVaultSecret(3, map[string]interface{}{
+		"name": "API",
+		"path": "secret/api",
+	}), metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update %s: %v", Kind, err)
+	}
+	if err := c.sync("default/app"); err == nil {
+		t.Error("Expected an error from sync(), got none")
+	}
+	assertCondition(t, client, ConditionSynced, "False")
+	assertCondition(t, client, ConditionReady, "False")
+
+	// deleted VaultSecrets are not synced anymore
+	if err := client.Resource(Resource).Namespace("default").Delete("app", nil); err != nil {
+		t.Fatalf("failed to delete %s: %v", Kind, err)
+	}
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("Expected the deleted %s to be removed, got %d entries", Kind, len(c.entries))
+	}
+}
+
+// rotatingLogical returns a new lease of a database secret on every read
+type rotatingLogical struct {
+	mu    sync.Mutex
+	reads int
+}
+
+func (l *rotatingLogical) Read(path string) (*api.Secret, error) {
+	if strings.HasPrefix(path, "sys/") {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reads++
+
+	return &api.Secret{
+		LeaseID:       fmt.Sprintf("database/creds/app/%d", l.reads),
+		LeaseDuration: 3,
+		Data:          map[string]interface{}{"username": fmt.Sprintf("user-%d", l.reads)},
+	}, nil
+}
+
+func (l *rotatingLogical) ReadWithData(path string, data map[string][]string) (*api.Secret, error) {
+	return l.Read(path)
+}
+
+func (l *rotatingLogical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	return nil, errors.New("not supported")
+}
+
+func TestController_Rotate(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+
+	revoked := make(chan string, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if strings.Contains(r.URL.Path, "revoke") {
+			select {
+			case revoked <- r.URL.Path + " " + string(body):
+			default:
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	vault, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), newVaultSecret(1, map[string]interface{}{
+		"name": "DB",
+		"path": "database/creds/app",
+	}))
+	kubeClient := kubeFake.NewSimpleClientset()
+	policy := retry.NewPolicy(logger, 1, 0, 0, 0, nil)
+
+	c := NewController(logger, client, kubeClient, vault, &rotatingLogical{}, policy, processor.ValueFormat{}, nil)
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+	defer func() {
+		for _, e := range c.entries {
+			e.stop(false)
+		}
+	}()
+
+	// the lease is not renewable, so it is rotated before it expires, reporting the status while rotating
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		secret, err := kubeClient.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get kubernetes secret: %v", err)
+		}
+		if string(secret.Data["DB_USERNAME"]) == "user-2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the secret to be rotated, got data %s", secret.Data)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	select {
+	case request := <-revoked:
+		if !strings.Contains(request, "database/creds/app/1") {
+			t.Errorf("Expected the replaced lease to be revoked, got request %q", request)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected the replaced lease to be revoked")
+	}
+	assertCondition(t, client, ConditionSynced, "True")
+}
+
+func TestController_UpdateStatusUnchanged(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+
+	// the lease is renewed right away, which is not reported in the status either
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"lease_id":"database/creds/app/1","lease_duration":3600,"renewable":true}`))
+	}))
+	defer server.Close()
+
+	vault, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logical := internalTesting.NewVaultClientLogical(&api.Secret{
+		LeaseID:       "database/creds/app/1",
+		LeaseDuration: 3600,
+		Renewable:     true,
+		Data:          map[string]interface{}{"username": "user-1"},
+	}, nil)
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), newVaultSecret(1, map[string]interface{}{
+		"name": "DB",
+		"path": "database/creds/app",
+	}))
+	kubeClient := kubeFake.NewSimpleClientset()
+	policy := retry.NewPolicy(logger, 1, 0, 0, 0, nil)
+
+	c := NewController(logger, client, kubeClient, vault, logical, policy, processor.ValueFormat{}, nil)
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+	e := c.entries["default/app"]
+	defer e.stop(false)
+
+	obj, err := client.Resource(Resource).Namespace("default").Get("app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultSecret, err := fromUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []LeaseStatus{{LeaseID: "database/creds/app/1", Names: []string{"DB"}}}
+	if !reflect.DeepEqual(exp, vaultSecret.Status.Leases) {
+		t.Errorf("Expected the leases %+v to be reported, got %+v", exp, vaultSecret.Status.Leases)
+	}
+
+	// the TTL of the lease decreased meanwhile, which is not written
+	time.Sleep(1100 * time.Millisecond)
+	client.ClearActions()
+	c.updateStatus("default/app", e)
+	c.updateStatus("default/app", e)
+	if updates := statusUpdates(client); updates != 0 {
+		t.Errorf("Expected the unchanged status not to be written, got %d updates", updates)
+	}
+
+	e.mu.Lock()
+	e.err = errors.New("failed")
+	e.mu.Unlock()
+	c.updateStatus("default/app", e)
+	if updates := statusUpdates(client); updates != 1 {
+		t.Errorf("Expected the changed status to be written once, got %d updates", updates)
+	}
+	assertCondition(t, client, ConditionSynced, "False")
+}
+
+func statusUpdates(client *dynamicFake.FakeDynamicClient) int {
+	updates := 0
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+			updates++
+		}
+	}
+
+	return updates
+}
+
+func TestController_SyncRenamedTarget(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	logical := internalTesting.NewVaultClientLogical(&api.Secret{Data: map[string]interface{}{"key": "abcd"}}, nil)
+
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), newVaultSecret(1, map[string]interface{}{
+		"name": "API",
+		"path": "secret/api",
+	}))
+	kubeClient := kubeFake.NewSimpleClientset()
+	policy := retry.NewPolicy(logger, 1, 0, 0, 0, nil)
+
+	c := NewController(logger, client, kubeClient, nil, logical, policy, processor.ValueFormat{}, nil)
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+
+	// the operator is restarted, so the previous target is only known by the status
+	c = NewController(logger, client, kubeClient, nil, logical, policy, processor.ValueFormat{}, nil)
+	renamed := newVaultSecret(2, map[string]interface{}{
+		"name": "API",
+		"path": "secret/api",
+	})
+	if err := unstructured.SetNestedField(renamed.Object, "api-secrets", "spec", "target", "name"); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := client.Resource(Resource).Namespace("default").Get("app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed.Object["status"] = obj.Object["status"]
+	if _, err := client.Resource(Resource).Namespace("default").Update(renamed, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("failed to update %s: %v", Kind, err)
+	}
+	if err := c.sync("default/app"); err != nil {
+		t.Fatalf("Got unexpected error from sync(): %v", err)
+	}
+
+	if _, err := kubeClient.CoreV1().Secrets("default").Get("api-secrets", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the renamed target secret to be created, got %v", err)
+	}
+	if _, err := kubeClient.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{}); err == nil {
+		t.Error("Expected the previous target secret to be deleted")
+	}
+}
+
+func TestController_SyncPathPrefixes(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	logical := internalTesting.NewVaultClientLogical(&api.Secret{Data: map[string]interface{}{"key": "abcd"}}, nil)
+
+	client := dynamicFake.NewSimpleDynamicClient(runtime.NewScheme(), newVaultSecret(1, map[string]interface{}{
+		"name": "API",
+		"path": "secret/other-team/api",
+	}))
+	kubeClient := kubeFake.NewSimpleClientset()
+	policy := retry.NewPolicy(logger, 1, 0, 0, 0, nil)
+
+	c := NewController(logger, client, kubeClient, nil, logical, policy, processor.ValueFormat{}, PathPrefixes{"default": {"secret/default/"}})
+	if err := c.sync("default/app"); err == nil {
+		t.Error("Expected an error for a path outside of the prefixes of the namespace, got none")
+	}
+	assertCondition(t, client, ConditionSynced, "False")
+
+	if _, err := kubeClient.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{}); err == nil {
+		t.Error("Expected no kubernetes secret to be written")
+	}
+
+	// watching all namespaces is refused without prefixes
+	c = NewController(logger, client, kubeClient, nil, logical, policy, processor.ValueFormat{}, nil)
+	if err := c.Run(context.Background(), "", 1); err == nil {
+		t.Error("Expected an error when watching all namespaces without prefixes, got none")
+	}
+}
+
+func assertCondition(t *testing.T, client *dynamicFake.FakeDynamicClient, conditionType, expected string) {
+	obj, err := client.Resource(Resource).Namespace("default").Get("app", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", Kind, err)
+	}
+
+	vaultSecret, err := fromUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, condition := range vaultSecret.Status.Conditions {
+		if condition.Type == conditionType {
+			if condition.Status != expected {
+				t.Errorf("Expected condition %s to be %s, got %s: %s", conditionType, expected, condition.Status, condition.Message)
+			}
+			return
+		}
+	}
+
+	t.Errorf("Expected condition %s to be set, got %+v", conditionType, vaultSecret.Status.Conditions)
+}
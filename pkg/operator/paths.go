@@ -0,0 +1,75 @@
+package operator
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+)
+
+// writePrefix marks the prefixes secrets may be fetched from by a write, like the "write:" marker of SECRET_ env vars
+const writePrefix = "write:"
+
+// PathPrefixes maps namespaces to the vault path prefixes the VaultSecrets of the namespace may read secrets from.
+// Secrets fetched by a write, e.g. to issue certificates, are only allowed below prefixes marked with "write:". The
+// operator fetches all secrets using its own auth token, so without them any VaultSecret could read every secret the
+// operator is allowed to and write arbitrary parameters to its endpoints.
+type PathPrefixes map[string][]string
+
+// check returns an error if any of the given secrets is not below one of the prefixes of the given namespace, or is
+// fetched by a write but not below one of its write prefixes. Secrets of all paths are allowed if no prefixes are
+// configured at all, which is only allowed if a single namespace is watched.
+func (p PathPrefixes) check(namespace string, secrets []*processor.Secret) error {
+	if len(p) == 0 {
+		return nil
+	}
+
+	prefixes, ok := p[namespace]
+	if !ok {
+		return fmt.Errorf("namespace %q is not allowed to read secrets from vault", namespace)
+	}
+
+	for _, secret := range secrets {
+		write := secret.Write != nil
+		if !allowedPath(strings.Trim(secret.Path, "/"), prefixes, write) {
+			action := "read"
+			if write {
+				action = "write"
+			}
+			return fmt.Errorf("secret %q is not allowed to %s path %q in namespace %q", secret.Name, action, secret.Path, namespace)
+		}
+	}
+
+	return nil
+}
+
+// allowedPath returns whether the given path is below one of the given prefixes, only considering the write prefixes
+// if write is set. A prefix matches whole path segments, so "secret/team-a" matches "secret/team-a/db" but not
+// "secret/team-ab", while a prefix ending with "/" only matches the paths below it. Paths leaving the prefix using ".."
+// are not allowed.
+func allowedPath(path string, prefixes []string, write bool) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == ".." {
+			return false
+		}
+	}
+
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(prefix, writePrefix) != write {
+			continue
+		}
+
+		prefix = strings.TrimPrefix(strings.TrimPrefix(prefix, writePrefix), "/")
+		switch {
+		case prefix == "":
+		case strings.HasSuffix(prefix, "/"):
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		case path == prefix || strings.HasPrefix(path, prefix+"/"):
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,55 @@
+package operator
+
+import (
+	"testing"
+
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+)
+
+func TestPathPrefixes_Check(t *testing.T) {
+	prefixes := PathPrefixes{
+		"team-a": {"secret/team-a/", "/database/creds/team-a", "write:pki/issue/team-a"},
+		"team-b": {},
+	}
+
+	tests := []struct {
+		namespace string
+		path      string
+		write     bool
+		allowed   bool
+	}{
+		{"team-a", "secret/team-a/db", false, true},
+		{"team-a", "/secret/team-a/db/", false, true},
+		{"team-a", "database/creds/team-a", false, true},
+		{"team-a", "database/creds/team-a/readonly", false, true},
+		{"team-a", "database/creds/team-ab", false, false},
+		{"team-a", "database/creds/team-a-readonly", false, false},
+		{"team-a", "secret/team-b/db", false, false},
+		{"team-a", "secret/team-a", false, false},
+		{"team-a", "secret/team-ab/db", false, false},
+		{"team-a", "secret/team-a/../team-b/db", false, false},
+		{"team-a", "pki/issue/team-a", true, true},
+		{"team-a", "pki/issue/team-a", false, false},
+		{"team-a", "pki/issue/team-ab", true, false},
+		{"team-a", "secret/team-a/db", true, false},
+		{"team-a", "database/creds/team-a", true, false},
+		{"team-b", "secret/team-b/db", false, false},
+		{"team-c", "secret/team-a/db", false, false},
+	}
+
+	for _, test := range tests {
+		secret := &processor.Secret{Name: "DB", Path: test.path}
+		if test.write {
+			secret.Write = map[string]interface{}{}
+		}
+
+		err := prefixes.check(test.namespace, []*processor.Secret{secret})
+		if allowed := err == nil; allowed != test.allowed {
+			t.Errorf("Expected path %q written: %v to be allowed in namespace %q: %v, got error %v", test.path, test.write, test.namespace, test.allowed, err)
+		}
+	}
+
+	if err := PathPrefixes(nil).check("team-c", []*processor.Secret{{Name: "DB", Path: "secret/team-a/db"}}); err != nil {
+		t.Errorf("Expected all paths to be allowed without prefixes, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package operator
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/libri-gmbh/kube-vault/pkg/processor"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// Group is the api group of the VaultSecret resource
+	Group = "kube-vault.libri.de"
+	// Version is the api version of the VaultSecret resource
+	Version = "v1alpha1"
+	// Kind is the kind of the VaultSecret resource
+	Kind = "VaultSecret"
+)
+
+// Resource identifies the VaultSecret resource
+var Resource = schema.GroupVersionResource{Group: Group, Version: Version, Resource: "vaultsecrets"}
+
+// Condition types of the VaultSecret status
+const (
+	// ConditionSynced tells whether the secrets were read and written into the target secret the last time
+	ConditionSynced = "Synced"
+	// ConditionLeasesValid tells whether all leases of the secrets are renewed and did not expire
+	ConditionLeasesValid = "LeasesValid"
+	// ConditionReady tells whether the target secret contains valid secrets
+	ConditionReady = "Ready"
+)
+
+// VaultSecret declares vault secrets to be kept in sync with a kubernetes secret
+type VaultSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VaultSecretSpec   `json:"spec"`
+	Status VaultSecretStatus `json:"status,omitempty"`
+}
+
+// VaultSecretSpec configures the secrets and the kubernetes secret they are written into
+type VaultSecretSpec struct {
+	// Secrets use the schema of the secrets of the config file
+	Secrets []*processor.Secret `json:"secrets"`
+	Target  VaultSecretTarget   `json:"target"`
+	// RefreshInterval is the interval static secrets like kv ones are read again in, e.g. "1h". They are only read
+	// along with rotated leases if empty.
+	RefreshInterval string `json:"refreshInterval,omitempty"`
+}
+
+// VaultSecretTarget configures the kubernetes secret the secrets are written into, which is created in the namespace
+// of the VaultSecret and garbage collected along with it
+type VaultSecretTarget struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// VaultSecretStatus describes the sync state of a VaultSecret
+type VaultSecretStatus struct {
+	ObservedGeneration int64         `json:"observedGeneration,omitempty"`
+	LastSynced         *metav1.Time  `json:"lastSynced,omitempty"`
+	Conditions         []Condition   `json:"conditions,omitempty"`
+	Leases             []LeaseStatus `json:"leases,omitempty"`
+	// Target is the name of the kubernetes secret written the last time, which is deleted once the target is renamed
+	Target string `json:"target,omitempty"`
+}
+
+// LeaseStatus names a lease contained in the target secret. Its TTL is left out, so the status only changes along with
+// the set of leases instead of every time it is reported.
+type LeaseStatus struct {
+	LeaseID string   `json:"leaseID"`
+	Names   []string `json:"names,omitempty"`
+}
+
+// Condition is a condition of the VaultSecret status
+type Condition struct {
+	Type               string      `json:"type"`
+	Status             string      `json:"status"`
+	Reason             string      `json:"reason,omitempty"`
+	Message            string      `json:"message,omitempty"`
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// refreshInterval parses the refresh interval of the spec, zero if none is given
+func (s *VaultSecretSpec) refreshInterval() (time.Duration, error) {
+	if s.RefreshInterval == "" {
+		return 0, nil
+	}
+
+	interval, err := time.ParseDuration(s.RefreshInterval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("invalid refresh interval %q", s.RefreshInterval)
+	}
+
+	return interval, nil
+}
+
+// setCondition sets the condition of the given type, keeping its transition time if the status did not change
+func (s *VaultSecretStatus) setCondition(conditionType, status, reason, message string, now metav1.Time) {
+	condition := Condition{
+		Type:               conditionType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: now,
+	}
+
+	for i, existing := range s.Conditions {
+		if existing.Type != conditionType {
+			continue
+		}
+
+		if existing.Status == status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		}
+		s.Conditions[i] = condition
+		return
+	}
+
+	s.Conditions = append(s.Conditions, condition)
+}
+
+// fromUnstructured converts the given object into a VaultSecret
+func fromUnstructured(obj *unstructured.Unstructured) (*VaultSecret, error) {
+	vaultSecret := &VaultSecret{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), vaultSecret); err != nil {
+		return nil, fmt.Errorf("failed to convert %s %s/%s: %v", Kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	return vaultSecret, nil
+}
@@ -1,107 +1,154 @@
 package processor
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"reflect"
+	"regexp"
 	"sort"
 	"strings"
 
 	"github.com/Sirupsen/logrus"
-	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
 )
 
-const envPrefix = "SECRET_"
+// Dialects of the env file
+const (
+	// DialectShell writes posix shell export statements to be sourced, the default
+	DialectShell = "shell"
+	// DialectDotenv writes KEY=value pairs as read by docker compose and dotenv libraries
+	DialectDotenv = "dotenv"
+	// DialectSystemd writes KEY=value pairs as read by the EnvironmentFile option of systemd units
+	DialectSystemd = "systemd"
+	// DialectFish writes fish shell set statements to be sourced
+	DialectFish = "fish"
+)
+
+// unquotedValue matches values which are written without quotes, as they mean the same to all dialects
+var unquotedValue = regexp.MustCompile(`^[A-Za-z0-9_@+=:,./-]+$`)
+
+// dialects format a single assignment of the env file in the dialect of the given name
+var dialects = map[string]func(key, value string) string{
+	DialectShell:   formatShell,
+	DialectDotenv:  formatDotenv,
+	DialectSystemd: formatSystemd,
+	DialectFish:    formatFish,
+}
 
 // Env handles variables consumed to and written to env vars / a file containing env vars
 type Env struct {
-	logger     *logrus.Entry
-	values     []string
-	envFile    string
-	leasesFile string
+	logger      *logrus.Entry
+	values      []string
+	envFile     string
+	dialect     string
+	valueFormat ValueFormat
+	leasesFile  string
+	observer    Observer
 }
 
-// NewEnv returns a new Env processor instance
-func NewEnv(logger *logrus.Entry, env []string, envFile, leasesFile string) *Env {
+// NewEnv returns a new Env processor instance, writing the env file in the given dialect and converting the secret
+// values using the given format. An empty dialect selects DialectShell.
+func NewEnv(logger *logrus.Entry, env []string, envFile, dialect string, format ValueFormat, leasesFile string) *Env {
 	return &Env{
-		logger:     logger,
-		values:     env,
-		envFile:    envFile,
-		leasesFile: leasesFile,
+		logger:      logger,
+		values:      env,
+		envFile:     envFile,
+		dialect:     dialect,
+		valueFormat: format,
+		leasesFile:  leasesFile,
 	}
 }
 
+// ValidDialect returns whether the given name is a supported dialect of the env file
+func ValidDialect(dialect string) bool {
+	_, ok := dialects[dialect]
+	return ok
+}
+
+// Observe sets the observer notified of the secrets each time the env file is rendered
+func (p *Env) Observe(observer Observer) {
+	p.observer = observer
+}
+
 // Process reads a list of environment variables and fetches the referenced secrets from vault,
 // storing the results in a file using the bash export syntax.
 func (p *Env) Process(logicalClient vaultLogicalClient) error {
-	var values []string
-	var secrets []*api.Secret
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
 
-	for _, envVar := range p.values {
-		if !strings.HasPrefix(envVar, envPrefix) {
-			p.logger.Debugf("Skipping %q, not prefixed with SECRET_", strings.Split(envVar, "=")[0])
-			continue
-		}
+// Refresh renders the env file again, fetching only the secrets not contained in the given leases
+func (p *Env) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refs, err := parseSecretRefs(p.logger, p.values)
+	if err != nil {
+		return nil, err
+	}
 
-		envVarName, uri := p.splitAndCleanEnv(envVar)
-		p.logger.Debugf("Loading env var %q from %q", envVarName, uri)
+	return refresh(p.logger, logicalClient, leases, refs, p.leasesFile, p)
+}
 
-		secret, err := logicalClient.Read(uri)
-		if err != nil {
-			return fmt.Errorf("failed to read secret endpoint %q: %v", uri, err)
-		}
+// Variables reads a list of environment variables and fetches the referenced secrets from vault, returning the
+// results as KEY=value pairs to be passed to a child process along with the leases of the fetched secrets.
+func (p *Env) Variables(logicalClient vaultLogicalClient) ([]string, []*lease.Lease, error) {
+	refs, err := parseSecretRefs(p.logger, p.values)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		values = append(values, p.formatExports(envVarName, secret.Data)...)
-		secrets = append(secrets, secret)
+	reader := newSecretReader(p.logger, logicalClient, nil)
+	secrets, err := reader.readAll(refs)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	valuesBytes := []byte(strings.Join(values, "\n"))
-	if err := p.writeFile(valuesBytes, p.envFile); err != nil {
+	return p.format(secrets, p.formatVariables), reader.leases, nil
+}
+
+// render writes the export statements of the given secrets into the env file
+func (p *Env) render(reader *secretReader, secrets []*secretValue) error {
+	values := p.format(secrets, p.formatExports)
+
+	if err := writeFile([]byte(strings.Join(values, "\n")), p.envFile); err != nil {
 		return fmt.Errorf("failed to write secrets file: %v", err)
 	}
 
-	if err := p.writeJSONFile(secrets, p.leasesFile); err != nil {
-		return fmt.Errorf("failed to write secrets leases file: %v", err)
+	if p.observer != nil {
+		data := map[string]interface{}{}
+		for _, secret := range secrets {
+			data[secret.name] = secret.data
+		}
+		p.observer.Update(data)
 	}
 
 	return nil
 }
 
-// splitAndCleanEnv receives an env var and splits it into key and value, which gets trimmed
-// prefixes and slashes respectively
-func (p *Env) splitAndCleanEnv(env string) (string, string) {
-	parts := strings.Split(env, "=")
-	return strings.Replace(parts[0], envPrefix, "", 1), strings.Trim(parts[1], "/")
+// format formats all given secrets using the given format func
+func (p *Env) format(secrets []*secretValue, format func(string, interface{}) []string) []string {
+	var values []string
+	for _, secret := range secrets {
+		values = append(values, format(secret.name, secret.data)...)
+	}
+
+	return values
 }
 
-// formatExports renders the export statements for the given secret, doing recursive calls if values are nested.
-// This method is using reflect to analyze the type of the secrets data in order to handle the values correctly.
-// If you think there is a better / more efficient / cleaner way to do so please open a PR and contribute the solution.
+// formatExports renders the export statements for the given secret, nested values are flattened into one
+// statement each.
 func (p *Env) formatExports(envVarName string, secret interface{}) []string {
-	var values []string
-
-	secretType := reflect.ValueOf(secret)
-	if secret == nil || (secretType.Kind() == reflect.Ptr && secretType.IsNil()) {
-		p.logger.Debugf("Skipping %q as its value is nil", envVarName)
-		return []string{}
+	values := []string{}
+	for key, value := range flattenValues(p.logger, p.valueFormat, envVarName, secret) {
+		values = append(values, p.formatExport(key, value))
 	}
 
-	switch secretType.Kind() {
-	case reflect.Map:
-		for _, key := range secretType.MapKeys() {
-			nestedKey := p.formatKey(envVarName, key.String())
-			values = append(values, p.formatExports(nestedKey, secretType.MapIndex(key).Interface())...)
-		}
-
-	case reflect.Slice:
-		return []string{p.formatExport(envVarName, strings.Join(secret.([]string), ","))}
+	sort.Strings(values)
 
-	case reflect.String:
-		return []string{p.formatExport(envVarName, secret.(string))}
+	return values
+}
 
-	default:
-		p.logger.Fatalf("Unknown type %q of secret value in env processor: %v", secretType.Kind().String(), secret)
+// formatVariables renders KEY=value pairs for the given secret, nested values are flattened into one pair each.
+func (p *Env) formatVariables(envVarName string, secret interface{}) []string {
+	values := []string{}
+	for key, value := range flattenValues(p.logger, p.valueFormat, envVarName, secret) {
+		values = append(values, key+"="+value)
 	}
 
 	sort.Strings(values)
@@ -109,29 +156,57 @@ func (p *Env) formatExports(envVarName string, secret interface{}) []string {
 	return values
 }
 
-// formatKey joins the given pieces of a key and converts it to upper case
-func (p *Env) formatKey(values ...string) string {
-	return strings.ToUpper(strings.Join(values, "_"))
+// formatExport formats an assignment of the given key and value in the dialect of the env file
+func (p *Env) formatExport(key, value string) string {
+	format, ok := dialects[p.dialect]
+	if !ok {
+		format = formatShell
+	}
+
+	return format(strings.ToUpper(key), value)
 }
 
-// formatExport formats a bash compatible export statement with the given key and value
-func (p *Env) formatExport(key, value string) string {
-	return fmt.Sprintf("export %v=%v", strings.ToUpper(key), value)
+// formatShell formats a posix shell export statement, quoting the value in single quotes if needed
+func formatShell(key, value string) string {
+	if unquotedValue.MatchString(value) {
+		return fmt.Sprintf("export %s=%s", key, value)
+	}
+
+	return fmt.Sprintf("export %s='%s'", key, strings.Replace(value, "'", `'\''`, -1))
 }
 
-func (p *Env) writeJSONFile(content interface{}, filePath string) error {
-	b, err := json.Marshal(content)
-	if err != nil {
-		return fmt.Errorf("failed to encode file content: %v", err)
+// formatDotenv formats a KEY=value pair. Values which need quotes are single quoted, as dotenv loaders do not
+// interpolate them, unless they contain single quotes or newlines which are escaped in double quotes instead.
+func formatDotenv(key, value string) string {
+	if unquotedValue.MatchString(value) {
+		return fmt.Sprintf("%s=%s", key, value)
+	}
+
+	if !strings.ContainsAny(value, "'\n") {
+		return fmt.Sprintf("%s='%s'", key, value)
 	}
 
-	return p.writeFile(b, filePath)
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`)
+	return fmt.Sprintf(`%s="%s"`, key, escaper.Replace(value))
 }
 
-func (p *Env) writeFile(content []byte, filePath string) error {
-	if err := ioutil.WriteFile(filePath, content, 0700); err != nil {
-		return fmt.Errorf("failed to write the env secrets file to %q: %v", p.envFile, err)
+// formatSystemd formats a KEY=value pair for a systemd EnvironmentFile, quoting the value in double quotes if needed.
+// Newlines are kept within the quotes.
+func formatSystemd(key, value string) string {
+	if unquotedValue.MatchString(value) {
+		return fmt.Sprintf("%s=%s", key, value)
 	}
 
-	return nil
+	escaper := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	return fmt.Sprintf(`%s="%s"`, key, escaper.Replace(value))
+}
+
+// formatFish formats a fish shell set statement exporting the variable, quoting the value in single quotes if needed
+func formatFish(key, value string) string {
+	if unquotedValue.MatchString(value) {
+		return fmt.Sprintf("set -gx %s %s", key, value)
+	}
+
+	escaper := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	return fmt.Sprintf("set -gx %s '%s'", key, escaper.Replace(value))
 }
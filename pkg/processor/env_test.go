@@ -3,22 +3,19 @@ package processor
 import (
 	"encoding/json"
 	"io/ioutil"
+	"os/exec"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/hashicorp/vault/api"
 	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
 )
 
 func TestEnv_FormatKey(t *testing.T) {
-	_, logger := internalTesting.NewLogger()
-	env := &Env{
-		logger: logger,
-	}
-
 	exp := "ASDF_QWERTZ"
-	res := env.formatKey("asdf", "qwertz")
+	res := formatKey("asdf", "qwertz")
 	if res != exp {
 		t.Errorf("Expected to get %s, got %s", exp, res)
 	}
@@ -37,15 +34,79 @@ func TestEnv_FormatExport(t *testing.T) {
 	}
 }
 
-func TestEnv_SplitAndCleanEnv(t *testing.T) {
-	_, logger := internalTesting.NewLogger()
-	env := &Env{
-		logger: logger,
+func TestEnv_FormatExportDialects(t *testing.T) {
+	tests := []struct {
+		dialect string
+		value   string
+		exp     string
+	}{
+		{DialectShell, "test1234", "export ASDF=test1234"},
+		{DialectShell, "", "export ASDF=''"},
+		{DialectShell, "it's $HOME", `export ASDF='it'\''s $HOME'`},
+		{DialectDotenv, "test1234", "ASDF=test1234"},
+		{DialectDotenv, "a $b `c`", "ASDF='a $b `c`'"},
+		{DialectDotenv, "it's\n$HOME", `ASDF="it's\n\$HOME"`},
+		{DialectSystemd, "test1234", "ASDF=test1234"},
+		{DialectSystemd, `a "$b" \c`, `ASDF="a \"\$b\" \\c"`},
+		{DialectFish, "test1234", "set -gx ASDF test1234"},
+		{DialectFish, `it's \ $HOME`, `set -gx ASDF 'it\'s \\ $HOME'`},
+	}
+
+	for _, test := range tests {
+		env := &Env{dialect: test.dialect}
+		if res := env.formatExport("asdf", test.value); res != test.exp {
+			t.Errorf("Expected to get %s in dialect %s, got %s", test.exp, test.dialect, res)
+		}
 	}
+}
+
+// roundTripValues are values which break the env file if they are not quoted properly
+var roundTripValues = []string{
+	"test1234",
+	"",
+	"with spaces",
+	"  leading and trailing  ",
+	"$HOME ${HOME} $(id) `id`",
+	`single ' and double " quotes`,
+	`back\slash\`,
+	"multiple\nlines\n",
+	"glob * ? [a]",
+	"semi; colon && pipe | amp & redirect > < # hash",
+	"!history ~tilde %percent",
+	"-dash",
+	"unicode ✓",
+}
+
+func TestEnv_FormatExportShellRoundTrip(t *testing.T) {
+	shells := map[string]string{"sh": DialectShell, "bash": DialectShell, "dash": DialectShell, "fish": DialectFish}
+
+	for shell, dialect := range shells {
+		if _, err := exec.LookPath(shell); err != nil {
+			t.Logf("Skipping round trip using %s: %v", shell, err)
+			continue
+		}
+
+		for _, value := range roundTripValues {
+			env := &Env{dialect: dialect}
+			script := env.formatExport("ASDF", value) + "\nprintf '%s' \"$ASDF\""
 
+			// nolint: gosec
+			out, err := exec.Command(shell, "-c", script).Output()
+			if err != nil {
+				t.Errorf("Failed to source %q using %s: %v", value, shell, err)
+				continue
+			}
+			if string(out) != value {
+				t.Errorf("Expected %q to survive sourcing using %s, got %q", value, shell, out)
+			}
+		}
+	}
+}
+
+func TestEnv_SplitAndCleanEnv(t *testing.T) {
 	expKey := "ASDF_QWERTZ"
 	expVal := "test1234"
-	key, val := env.splitAndCleanEnv("SECRET_ASDF_QWERTZ=/test1234/")
+	key, val := splitAndCleanEnv("SECRET_ASDF_QWERTZ=/test1234/")
 	if expKey != key {
 		t.Errorf("Expected to get key %s, got %s", expKey, key)
 	}
@@ -139,6 +200,66 @@ func TestEnv_FormatExportsMapInterfaceNested(t *testing.T) {
 	}
 }
 
+func TestEnv_FormatExportsTyped(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	secret := map[string]interface{}{
+		"ttl":       json.Number("3600"),
+		"renewable": true,
+		"ratio":     0.5,
+		"port":      5432,
+		"policies":  []interface{}{"default", "app"},
+		"ports":     []interface{}{json.Number("80"), json.Number("443")},
+		"users":     []interface{}{map[string]interface{}{"name": "app"}},
+		"endpoint":  map[string]interface{}{"url": "http://asdf.net/"},
+		"labels":    map[interface{}]interface{}{"app": "example"},
+		"cert":      []byte("test1234"),
+		"empty":     nil,
+		"invalid":   func() {},
+	}
+
+	tests := []struct {
+		format ValueFormat
+		exp    []string
+	}{
+		{ValueFormat{}, []string{
+			"export ASDF_CERT=test1234",
+			"export ASDF_ENDPOINT_URL=http://asdf.net/",
+			"export ASDF_LABELS_APP=example",
+			"export ASDF_POLICIES=default,app",
+			"export ASDF_PORT=5432",
+			"export ASDF_PORTS=80,443",
+			"export ASDF_RATIO=0.5",
+			"export ASDF_RENEWABLE=true",
+			"export ASDF_TTL=3600",
+			`export ASDF_USERS='[{"name":"app"}]'`,
+		}},
+		{ValueFormat{ListSeparator: " ", NestedJSON: true}, []string{
+			"export ASDF_CERT=test1234",
+			`export ASDF_ENDPOINT='{"url":"http://asdf.net/"}'`,
+			`export ASDF_LABELS='{"app":"example"}'`,
+			"export ASDF_POLICIES='default app'",
+			"export ASDF_PORT=5432",
+			"export ASDF_PORTS='80 443'",
+			"export ASDF_RATIO=0.5",
+			"export ASDF_RENEWABLE=true",
+			"export ASDF_TTL=3600",
+			`export ASDF_USERS='[{"name":"app"}]'`,
+		}},
+	}
+
+	for _, test := range tests {
+		env := &Env{
+			logger:      logger,
+			valueFormat: test.format,
+		}
+
+		res := env.formatExports("ASDF", secret)
+		if !reflect.DeepEqual(test.exp, res) {
+			t.Errorf("Expected to get %s with format %+v, got %s", test.exp, test.format, res)
+		}
+	}
+}
+
 func TestEnv_Process(t *testing.T) {
 	secret := &api.Secret{
 		Data: map[string]interface{}{
@@ -209,3 +330,226 @@ func TestEnv_Process(t *testing.T) {
 		t.Errorf("Invalid amount of leases, expected %d, got %d", 1, len(leases))
 	}
 }
+
+func TestEnv_ProcessKV2(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(nil, nil)
+	client.PathResults = map[string]*api.Secret{
+		"sys/internal/ui/mounts/kv/app/db": {
+			Data: map[string]interface{}{
+				"path":    "kv/",
+				"type":    "kv",
+				"options": map[string]interface{}{"version": "2"},
+			},
+		},
+		"kv/data/app/db": {
+			Data: map[string]interface{}{
+				"data":     map[string]interface{}{"password": "test5678"},
+				"metadata": map[string]interface{}{"version": "3"},
+			},
+		},
+	}
+
+	envFile, envFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create envFile: %v", err)
+	}
+	defer envFileCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	for i, value := range []string{"kv/app/db", "kv/app/db?version=3", "kv2:kv/app/db", "kv2:kv/app/db"} {
+		if i == 3 {
+			// the marker has to work without being allowed to read the mount information
+			delete(client.PathResults, "sys/internal/ui/mounts/kv/app/db")
+		}
+
+		env := NewEnv(logger, []string{"SECRET_DB=" + value}, envFile, DialectShell, ValueFormat{}, leasesFile)
+		if err := env.Process(client); err != nil {
+			t.Fatalf("Got unexpected error from Process() for %q: %v", value, err)
+		}
+
+		bValues, err := ioutil.ReadFile(envFile)
+		if err != nil {
+			t.Fatalf("failed to read written env file: %v", err)
+		}
+
+		exp := "export DB_PASSWORD=test5678"
+		if string(bValues) != exp {
+			t.Errorf("Expected to get %s for %q, got %s", exp, value, string(bValues))
+		}
+	}
+}
+
+func TestEnv_ProcessFieldSelection(t *testing.T) {
+	secret := &api.Secret{
+		Data: map[string]interface{}{
+			"username": "test1234",
+			"password": "test5678",
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(secret, nil)
+
+	envFile, envFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create envFile: %v", err)
+	}
+	defer envFileCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	env := NewEnv(logger, []string{"SECRET_DB_PASSWORD=database/creds/app#password"}, envFile, DialectShell, ValueFormat{}, leasesFile)
+	if err := env.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	bValues, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("failed to read written env file: %v", err)
+	}
+
+	exp := "export DB_PASSWORD=test5678"
+	if string(bValues) != exp {
+		t.Errorf("Expected to get %s, got %s", exp, string(bValues))
+	}
+
+	env = NewEnv(logger, []string{"SECRET_DB_PASSWORD=database/creds/app#data.password"}, envFile, DialectShell, ValueFormat{}, leasesFile)
+	if err := env.Process(client); err == nil {
+		t.Errorf("Expected an error for a missing field, got none")
+	}
+}
+
+func TestEnv_Variables(t *testing.T) {
+	secret := &api.Secret{
+		Data: map[string]interface{}{
+			"username": "test1234",
+			"password": "test5678",
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(secret, nil)
+
+	env := NewEnv(logger, []string{"HOME=/root", "SECRET_ASDF_QWERTZ=secrets/asdf/qwertz"}, "", DialectShell, ValueFormat{}, "")
+	exp := []string{
+		"ASDF_QWERTZ_PASSWORD=test5678",
+		"ASDF_QWERTZ_USERNAME=test1234",
+	}
+
+	values, secrets, err := env.Variables(client)
+	if err != nil {
+		t.Fatalf("Got unexpected error from Variables(): %v", err)
+	}
+
+	if !reflect.DeepEqual(exp, values) {
+		t.Errorf("Expected to get %s, got %s", exp, values)
+	}
+
+	if len(secrets) != 1 {
+		t.Errorf("Invalid amount of secrets, expected %d, got %d", 1, len(secrets))
+	}
+}
+
+func TestEnv_Refresh(t *testing.T) {
+	secret := &api.Secret{
+		LeaseID: "aws/creds/app/5678",
+		Data: map[string]interface{}{
+			"access_key": "test5678",
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(secret, nil)
+
+	envFile, envFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create envFile: %v", err)
+	}
+	defer envFileCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	// the lease of the database secret is still valid, while the aws one expired and has to be read again
+	current := lease.NewLease(&api.Secret{
+		LeaseID: "database/creds/app/1234",
+		Data:    map[string]interface{}{"username": "test1234"},
+	}, "database/creds/app")
+
+	env := NewEnv(logger, []string{"SECRET_AWS=aws/creds/app", "SECRET_DB=database/creds/app"}, envFile, DialectShell, ValueFormat{}, leasesFile)
+	leases, err := env.Refresh(client, []*lease.Lease{current})
+	if err != nil {
+		t.Fatalf("Got unexpected error from Refresh(): %v", err)
+	}
+
+	bValues, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("failed to read written env file: %v", err)
+	}
+
+	exp := []string{
+		"export AWS_ACCESS_KEY=test5678",
+		"export DB_USERNAME=test1234",
+	}
+	if content := strings.Split(string(bValues), "\n"); !reflect.DeepEqual(exp, content) {
+		t.Errorf("Expected to get %s, got %s", exp, content)
+	}
+
+	if len(leases) != 2 || leases[1] != current {
+		t.Fatalf("Expected the current lease to be reused, got %v", leases)
+	}
+
+	if leases[0].Path != "aws/creds/app" || !reflect.DeepEqual(leases[0].Names, []string{"AWS"}) {
+		t.Errorf("Expected the new lease to reference aws/creds/app as AWS, got %q as %v", leases[0].Path, leases[0].Names)
+	}
+}
+
+type recordingObserver struct {
+	secrets map[string]interface{}
+}
+
+func (o *recordingObserver) Update(secrets map[string]interface{}) {
+	o.secrets = secrets
+}
+
+func TestEnv_ProcessObserver(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(&api.Secret{
+		Data: map[string]interface{}{"username": "test1234", "password": "test5678"},
+	}, nil)
+
+	envFile, envFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create envFile: %v", err)
+	}
+	defer envFileCleanup()
+
+	observer := &recordingObserver{}
+	env := NewEnv(logger, []string{"SECRET_DB=database/creds/app", "SECRET_DB_PASSWORD=database/creds/app#password"}, envFile, DialectShell, ValueFormat{}, "")
+	env.Observe(observer)
+
+	if err := env.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"DB":          map[string]interface{}{"username": "test1234", "password": "test5678"},
+		"DB_PASSWORD": "test5678",
+	}
+	if !reflect.DeepEqual(observer.secrets, expected) {
+		t.Errorf("Expected observer to be notified of %v, got %v", expected, observer.secrets)
+	}
+}
@@ -0,0 +1,14 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+func writeFile(content []byte, filePath string) error {
+	if err := ioutil.WriteFile(filePath, content, 0700); err != nil {
+		return fmt.Errorf("failed to write file %q: %v", filePath, err)
+	}
+
+	return nil
+}
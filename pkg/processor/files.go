@@ -0,0 +1,258 @@
+package processor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+)
+
+const (
+	filesDataDir    = "..data"
+	filesDataDirTmp = "..data_tmp"
+)
+
+// Files writes each value of the secrets referenced by SECRET_ env vars into a file of its own, using the layout of a
+// mounted kubernetes secret volume: SECRET_AWS results in files like AWS/ACCESS_KEY, while selected fields result in
+// a single file named after the SECRET_ env var.
+// Updates are atomic, using the symlink swap of the kubelet: the files are written into a new timestamped directory
+// first, which the ..data symlink is pointed to afterwards, while the entries of the directory are symlinks into
+// ..data.
+type Files struct {
+	logger      *logrus.Entry
+	values      []string
+	dir         string
+	mode        os.FileMode
+	uid         int
+	gid         int
+	valueFormat ValueFormat
+	leasesFile  string
+	// modes override the mode of the files with the given base names
+	modes map[string]os.FileMode
+}
+
+// NewFiles returns a new Files processor instance. The written files are owned by uid and gid, which are left
+// unchanged if set to -1, and contain the secret values converted using the given format.
+func NewFiles(logger *logrus.Entry, env []string, dir string, mode os.FileMode, uid, gid int, format ValueFormat, leasesFile string) *Files {
+	return &Files{
+		logger:      logger,
+		values:      env,
+		dir:         dir,
+		mode:        mode,
+		uid:         uid,
+		gid:         gid,
+		valueFormat: format,
+		leasesFile:  leasesFile,
+	}
+}
+
+// Process fetches the secrets referenced by the SECRET_ env vars and writes their values into the files directory
+func (p *Files) Process(logicalClient vaultLogicalClient) error {
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
+
+// Refresh writes the files again, fetching only the secrets not contained in the given leases
+func (p *Files) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refs, err := parseSecretRefs(p.logger, p.values)
+	if err != nil {
+		return nil, err
+	}
+
+	return refresh(p.logger, logicalClient, leases, refs, p.leasesFile, p)
+}
+
+// render writes the values of the given secrets into the files directory
+func (p *Files) render(reader *secretReader, secrets []*secretValue) error {
+	files := map[string]string{}
+	for _, secret := range secrets {
+		if reflect.ValueOf(secret.data).Kind() != reflect.Map {
+			for key, value := range flattenValues(p.logger, p.valueFormat, secret.name, secret.data) {
+				if p.validName(key) {
+					files[key] = value
+				}
+			}
+			continue
+		}
+
+		dir := formatKey(secret.name)
+		if !p.validName(dir) {
+			continue
+		}
+
+		for key, value := range flattenValues(p.logger, p.valueFormat, "", secret.data) {
+			if p.validName(key) {
+				files[filepath.Join(dir, key)] = value
+			}
+		}
+	}
+
+	if err := p.write(files); err != nil {
+		return fmt.Errorf("failed to write secret files: %v", err)
+	}
+
+	return nil
+}
+
+// write atomically replaces the content of the files directory with the given files, keyed by their relative path
+func (p *Files) write(files map[string]string) error {
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return err
+	}
+
+	dataDirLink := filepath.Join(p.dir, filesDataDir)
+	oldDataDir, err := os.Readlink(dataDirLink)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	dataDir, err := ioutil.TempDir(p.dir, time.Now().UTC().Format("..2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+
+	if err := p.writeDataDir(dataDir, files); err != nil {
+		_ = os.RemoveAll(dataDir)
+		return err
+	}
+
+	// a link left behind by a previous run which was interrupted before renaming it would let the symlink call fail
+	tmpLink := filepath.Join(p.dir, filesDataDirTmp)
+	if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+		_ = os.RemoveAll(dataDir)
+		return err
+	}
+
+	if err := os.Symlink(filepath.Base(dataDir), tmpLink); err != nil {
+		_ = os.RemoveAll(dataDir)
+		return err
+	}
+
+	if err := os.Rename(tmpLink, dataDirLink); err != nil {
+		_ = os.Remove(tmpLink)
+		_ = os.RemoveAll(dataDir)
+		return err
+	}
+
+	p.logger.Debugf("Pointed %q to %q", dataDirLink, dataDir)
+
+	if err := p.updateEntryLinks(files); err != nil {
+		return err
+	}
+
+	if oldDataDir != "" {
+		if err := os.RemoveAll(filepath.Join(p.dir, oldDataDir)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validName returns whether the given key of a secret can be used as a file name, the keys come from vault and must
+// not point outside of the files directory. Invalid keys are skipped with a warning.
+func (p *Files) validName(name string) bool {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		p.logger.Warnf("Skipping secret value %q, as it is no valid file name", name)
+		return false
+	}
+
+	return true
+}
+
+func (p *Files) writeDataDir(dataDir string, files map[string]string) error {
+	if err := os.Chmod(dataDir, 0755); err != nil {
+		return err
+	}
+
+	if err := p.chown(dataDir); err != nil {
+		return err
+	}
+
+	for path, content := range files {
+		filePath := filepath.Join(dataDir, path)
+		if rel, err := filepath.Rel(dataDir, filePath); err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("file %q is not below the data dir", path)
+		}
+		if dir := filepath.Dir(filePath); dir != dataDir {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			if err := p.chown(dir); err != nil {
+				return err
+			}
+		}
+
+		mode := p.mode
+		if override, ok := p.modes[filepath.Base(filePath)]; ok {
+			mode = override
+		}
+
+		if err := ioutil.WriteFile(filePath, []byte(content), mode); err != nil {
+			return err
+		}
+
+		// the mode passed to WriteFile is subject to the umask
+		if err := os.Chmod(filePath, mode); err != nil {
+			return err
+		}
+
+		if err := p.chown(filePath); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateEntryLinks creates the symlinks of the top level entries into ..data and removes the ones of entries which
+// do not exist anymore
+func (p *Files) updateEntryLinks(files map[string]string) error {
+	entries := map[string]bool{}
+	for path := range files {
+		entries[strings.Split(path, string(filepath.Separator))[0]] = true
+	}
+
+	for entry := range entries {
+		link := filepath.Join(p.dir, entry)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+
+		if err := os.Symlink(filepath.Join(filesDataDir, entry), link); err != nil {
+			return err
+		}
+	}
+
+	existing, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range existing {
+		if strings.HasPrefix(info.Name(), "..") || entries[info.Name()] || info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		p.logger.Debugf("Removing stale entry %q", info.Name())
+		if err := os.Remove(filepath.Join(p.dir, info.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Files) chown(path string) error {
+	if p.uid == -1 && p.gid == -1 {
+		return nil
+	}
+
+	return os.Chown(path, p.uid, p.gid)
+}
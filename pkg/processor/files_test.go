@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func TestFiles_Process(t *testing.T) {
+	secret := &api.Secret{
+		Data: map[string]interface{}{
+			"access_key": "test1234",
+			"secret_key": "test5678",
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(secret, nil)
+
+	dir, dirCleanup, err := internalTesting.CreateTempDir(logger)
+	if err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	defer dirCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	env := []string{"SECRET_AWS=aws/creds/app", "SECRET_SECRET_KEY=aws/creds/app#secret_key"}
+	p := NewFiles(logger, env, dir, 0640, -1, -1, ValueFormat{}, leasesFile)
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	exp := map[string]string{
+		"AWS/ACCESS_KEY": "test1234",
+		"AWS/SECRET_KEY": "test5678",
+		"SECRET_KEY":     "test5678",
+	}
+	for path, content := range exp {
+		b, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("failed to read file %q: %v", path, err)
+		}
+		if string(b) != content {
+			t.Errorf("Expected to get %q in %q, got %q", content, path, string(b))
+		}
+
+		info, err := os.Stat(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatalf("failed to stat file %q: %v", path, err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("Expected mode %v of %q, got %v", os.FileMode(0640), path, info.Mode().Perm())
+		}
+	}
+
+	// a second run swaps the data dir and removes entries which are gone
+	secret.Data["access_key"] = "test4321"
+	p = NewFiles(logger, []string{"SECRET_AWS=aws/creds/app"}, dir, 0640, -1, -1, ValueFormat{}, leasesFile)
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "AWS/ACCESS_KEY"))
+	if err != nil {
+		t.Fatalf("failed to read updated file: %v", err)
+	}
+	if string(b) != "test4321" {
+		t.Errorf("Expected to get %q after update, got %q", "test4321", string(b))
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read dir: %v", err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+
+	// the timestamped data dir is the only dir left, everything else are symlinks
+	if expNames := []string{"..data", "AWS"}; !reflect.DeepEqual(expNames, names) {
+		t.Errorf("Expected entries %v, got %v", expNames, names)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected exactly one data dir, got entries %v", entries)
+	}
+}
+
+func TestFiles_ProcessStaleTmpLink(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(&api.Secret{Data: map[string]interface{}{"key": "test1234"}}, nil)
+
+	dir, dirCleanup, err := internalTesting.CreateTempDir(logger)
+	if err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	defer dirCleanup()
+
+	// a run interrupted between creating and renaming the link leaves it behind
+	if err := os.Symlink("..2019_01_01_00_00_00.123", filepath.Join(dir, filesDataDirTmp)); err != nil {
+		t.Fatalf("failed to create stale link: %v", err)
+	}
+
+	p := NewFiles(logger, []string{"SECRET_API=secret/api"}, dir, 0640, -1, -1, ValueFormat{}, "")
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "API/KEY"))
+	if err != nil {
+		t.Fatalf("failed to read file: %v", err)
+	}
+	if string(b) != "test1234" {
+		t.Errorf("Expected to get %q, got %q", "test1234", string(b))
+	}
+
+	if _, err := os.Lstat(filepath.Join(dir, filesDataDirTmp)); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale link to be gone, got %v", err)
+	}
+}
+
+func TestFiles_ProcessTraversalKeys(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(&api.Secret{Data: map[string]interface{}{
+		"../../escaped": "test1234",
+		"/absolute":     "test1234",
+		"..":            "test1234",
+		"nested":        map[string]interface{}{"../escaped": "test1234"},
+		"key":           "test5678",
+	}}, nil)
+
+	parent, parentCleanup, err := internalTesting.CreateTempDir(logger)
+	if err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	defer parentCleanup()
+	dir := filepath.Join(parent, "secrets", "files")
+
+	p := NewFiles(logger, []string{"SECRET_API=secret/api"}, dir, 0640, -1, -1, ValueFormat{}, "")
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	// the only regular file written is the valid key inside the data dir
+	var written []string
+	err = filepath.Walk(parent, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			written = append(written, path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("failed to walk dir: %v", err)
+	}
+
+	if len(written) != 1 || !strings.HasPrefix(written[0], dir) || !strings.HasSuffix(written[0], filepath.Join("API", "KEY")) {
+		t.Errorf("Expected only API/KEY to be written below %q, got %v", dir, written)
+	}
+}
@@ -0,0 +1,136 @@
+package processor
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// KubernetesSecret writes the secrets referenced by SECRET_ env vars into a kubernetes secret, keyed like the env
+// vars of the env processor, so it can be consumed using envFrom or a secret volume. The kubernetes secret is created
+// if it does not exist and replaced on every refresh, which requires the service account to be allowed to get, create
+// and update it.
+type KubernetesSecret struct {
+	logger      *logrus.Entry
+	values      []string
+	client      kubernetes.Interface
+	namespace   string
+	name        string
+	labels      map[string]string
+	owner       *metav1.OwnerReference
+	valueFormat ValueFormat
+	leasesFile  string
+}
+
+// NewKubernetesSecret returns a new KubernetesSecret processor instance, writing the kubernetes secret with the given
+// namespace, name and labels. The kubernetes secret is garbage collected along with the owner, if one is given.
+func NewKubernetesSecret(logger *logrus.Entry, env []string, client kubernetes.Interface, namespace, name string, labels map[string]string, owner *metav1.OwnerReference, format ValueFormat, leasesFile string) *KubernetesSecret {
+	return &KubernetesSecret{
+		logger:      logger,
+		values:      env,
+		client:      client,
+		namespace:   namespace,
+		name:        name,
+		labels:      labels,
+		owner:       owner,
+		valueFormat: format,
+		leasesFile:  leasesFile,
+	}
+}
+
+// Process fetches the secrets referenced by the SECRET_ env vars and writes them into the kubernetes secret
+func (p *KubernetesSecret) Process(logicalClient vaultLogicalClient) error {
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
+
+// Refresh writes the kubernetes secret again, fetching only the secrets not contained in the given leases
+func (p *KubernetesSecret) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refs, err := parseSecretRefs(p.logger, p.values)
+	if err != nil {
+		return nil, err
+	}
+
+	return refresh(p.logger, logicalClient, leases, refs, p.leasesFile, p)
+}
+
+// render replaces the data of the kubernetes secret with the values of the given secrets
+func (p *KubernetesSecret) render(reader *secretReader, secrets []*secretValue) error {
+	data := map[string][]byte{}
+	for _, secret := range secrets {
+		for key, value := range flattenValues(p.logger, p.valueFormat, secret.name, secret.data) {
+			data[key] = []byte(value)
+		}
+	}
+
+	if err := p.write(data); err != nil {
+		return fmt.Errorf("failed to write kubernetes secret %s/%s: %v", p.namespace, p.name, err)
+	}
+
+	return nil
+}
+
+func (p *KubernetesSecret) write(data map[string][]byte) error {
+	secrets := p.client.CoreV1().Secrets(p.namespace)
+
+	existing, err := secrets.Get(p.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: p.name, Namespace: p.namespace},
+			Type:       corev1.SecretTypeOpaque,
+		}
+		p.apply(secret, data)
+
+		if _, err := secrets.Create(secret); err != nil {
+			return err
+		}
+
+		p.logger.Infof("Created kubernetes secret %s/%s", p.namespace, p.name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	secret := existing.DeepCopy()
+	p.apply(secret, data)
+	if reflect.DeepEqual(existing, secret) {
+		p.logger.Debugf("Kubernetes secret %s/%s is up to date", p.namespace, p.name)
+		return nil
+	}
+
+	if _, err := secrets.Update(secret); err != nil {
+		return err
+	}
+
+	p.logger.Infof("Updated kubernetes secret %s/%s", p.namespace, p.name)
+	return nil
+}
+
+// apply sets the data, labels and owner of the given kubernetes secret, keeping other labels and owners
+func (p *KubernetesSecret) apply(secret *corev1.Secret, data map[string][]byte) {
+	secret.Data = data
+
+	if len(p.labels) > 0 && secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	for key, value := range p.labels {
+		secret.Labels[key] = value
+	}
+
+	if p.owner == nil {
+		return
+	}
+	for _, owner := range secret.OwnerReferences {
+		if owner.UID == p.owner.UID {
+			return
+		}
+	}
+	secret.OwnerReferences = append(secret.OwnerReferences, *p.owner)
+}
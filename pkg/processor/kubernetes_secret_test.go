@@ -0,0 +1,83 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubernetesSecret_Process(t *testing.T) {
+	secret := &api.Secret{
+		Data: map[string]interface{}{
+			"username": "test1234",
+			"password": "test5678",
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(secret, nil)
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	clientset := fake.NewSimpleClientset()
+	labels := map[string]string{"app": "test"}
+	owner := &metav1.OwnerReference{APIVersion: "v1", Kind: "Pod", Name: "app", UID: "1234"}
+
+	p := NewKubernetesSecret(logger, []string{"SECRET_DB=database/creds/app"}, clientset, "default", "app-secrets", labels, owner, ValueFormat{}, leasesFile)
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	res, err := clientset.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Expected the kubernetes secret to be created, got %v", err)
+	}
+
+	exp := map[string][]byte{"DB_USERNAME": []byte("test1234"), "DB_PASSWORD": []byte("test5678")}
+	if !reflect.DeepEqual(exp, res.Data) {
+		t.Errorf("Expected to get data %s, got %s", exp, res.Data)
+	}
+	if !reflect.DeepEqual(labels, res.Labels) {
+		t.Errorf("Expected to get labels %v, got %v", labels, res.Labels)
+	}
+	if len(res.OwnerReferences) != 1 || res.OwnerReferences[0] != *owner {
+		t.Errorf("Expected to get owner %v, got %v", owner, res.OwnerReferences)
+	}
+	if res.Type != corev1.SecretTypeOpaque {
+		t.Errorf("Expected to get type %s, got %s", corev1.SecretTypeOpaque, res.Type)
+	}
+
+	// a rotation replaces the data, keeping foreign labels and owners
+	res.Labels["team"] = "payments"
+	res.OwnerReferences = append(res.OwnerReferences, metav1.OwnerReference{Kind: "Deployment", Name: "app", UID: "5678"})
+	if _, err := clientset.CoreV1().Secrets("default").Update(res); err != nil {
+		t.Fatalf("failed to update kubernetes secret: %v", err)
+	}
+
+	secret.Data = map[string]interface{}{"username": "test4321"}
+	if _, err := p.Refresh(client, nil); err != nil {
+		t.Fatalf("Got unexpected error from Refresh(): %v", err)
+	}
+
+	res, err = clientset.CoreV1().Secrets("default").Get("app-secrets", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get kubernetes secret: %v", err)
+	}
+
+	exp = map[string][]byte{"DB_USERNAME": []byte("test4321")}
+	if !reflect.DeepEqual(exp, res.Data) {
+		t.Errorf("Expected to get data %s after the rotation, got %s", exp, res.Data)
+	}
+	if res.Labels["team"] != "payments" || len(res.OwnerReferences) != 2 {
+		t.Errorf("Expected foreign labels and owners to be kept, got %v and %v", res.Labels, res.OwnerReferences)
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+)
+
+// Multi renders the secrets configured in the config file and by SECRET_ env vars into several outputs, reading
+// each secret only once
+type Multi struct {
+	logger      *logrus.Entry
+	secrets     []*Secret
+	values      []string
+	outputs     []Output
+	valueFormat ValueFormat
+	leasesFile  string
+}
+
+// NewMulti returns a new Multi processor instance. The SECRET_ env vars of the given env are rendered along with the
+// given secrets, the secrets configured for the outputs themselves are ignored. The format is used to convert the
+// values returned by Variables.
+func NewMulti(logger *logrus.Entry, secrets []*Secret, env []string, outputs []Output, format ValueFormat, leasesFile string) *Multi {
+	return &Multi{
+		logger:      logger,
+		secrets:     secrets,
+		values:      env,
+		outputs:     outputs,
+		valueFormat: format,
+		leasesFile:  leasesFile,
+	}
+}
+
+// Process fetches the configured secrets and renders all outputs
+func (p *Multi) Process(logicalClient vaultLogicalClient) error {
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
+
+// Refresh renders all outputs again, fetching only the secrets not contained in the given leases
+func (p *Multi) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refs, err := secretRefs(p.logger, p.secrets, p.values)
+	if err != nil {
+		return nil, err
+	}
+
+	return refresh(p.logger, logicalClient, leases, refs, p.leasesFile, p.outputs...)
+}
+
+// Variables fetches the configured secrets, returning them as KEY=value pairs to be passed to a child process along
+// with the leases of the fetched secrets
+func (p *Multi) Variables(logicalClient vaultLogicalClient) ([]string, []*lease.Lease, error) {
+	refs, err := secretRefs(p.logger, p.secrets, p.values)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	reader := newSecretReader(p.logger, logicalClient, nil)
+	secrets, err := reader.readAll(refs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := NewEnv(p.logger, nil, "", "", p.valueFormat, "")
+	return env.format(secrets, env.formatVariables), reader.leases, nil
+}
+
+// refresh reads the secrets of the given refs and renders them into all outputs, writing the leases of the read
+// secrets to the leases file afterwards, if one is given
+func refresh(logger *logrus.Entry, logicalClient vaultLogicalClient, leases []*lease.Lease, refs []*secretRef, leasesFile string, outputs ...Output) ([]*lease.Lease, error) {
+	reader := newSecretReader(logger, logicalClient, leases)
+
+	secrets, err := reader.readAll(refs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, output := range outputs {
+		if err := output.render(reader, secrets); err != nil {
+			return nil, err
+		}
+	}
+
+	if leasesFile == "" {
+		return reader.leases, nil
+	}
+
+	if err := lease.SaveLeases(leasesFile, reader.leases); err != nil {
+		return nil, err
+	}
+
+	return reader.leases, nil
+}
@@ -0,0 +1,174 @@
+package processor
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func TestMulti_Process(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(nil, nil)
+	client.PathResults = map[string]*api.Secret{
+		"database/creds/app": {
+			LeaseID:       "database/creds/app/abc",
+			LeaseDuration: 3600,
+			Renewable:     true,
+			Data:          map[string]interface{}{"username": "test1234", "password": "test5678", "ttl": "3600"},
+		},
+		"secret/api": {
+			Data: map[string]interface{}{"key": "abcd"},
+		},
+		"secret/missing": nil,
+	}
+
+	envFile, envFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create envFile: %v", err)
+	}
+	defer envFileCleanup()
+
+	jsonFile, jsonFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create jsonFile: %v", err)
+	}
+	defer jsonFileCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	secrets := []*Secret{
+		{
+			Name:   "DB",
+			Path:   "database/creds/app",
+			Fields: []string{"username", "password"},
+			Rename: map[string]string{"username": "user"},
+		},
+		{Name: "MISSING", Path: "secret/missing", Optional: true},
+	}
+	outputs := []Output{
+		NewEnv(logger, nil, envFile, DialectShell, ValueFormat{}, ""),
+		NewJSON(logger, nil, jsonFile, ""),
+	}
+	env := []string{"HOME=/root", "SECRET_API_KEY=secret/api#key"}
+
+	p := NewMulti(logger, secrets, env, outputs, ValueFormat{}, leasesFile)
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	content, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		t.Fatalf("failed to read written env file: %v", err)
+	}
+
+	exp := []string{
+		"export DB_PASSWORD=test5678",
+		"export DB_USER=test1234",
+		"export API_KEY=abcd",
+	}
+	if res := strings.Split(string(content), "\n"); !reflect.DeepEqual(exp, res) {
+		t.Errorf("Expected to get %s, got %s", exp, res)
+	}
+
+	content, err = ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatalf("failed to read written json file: %v", err)
+	}
+
+	expJSON := `{
+  "API_KEY": "abcd",
+  "DB": {
+    "password": "test5678",
+    "user": "test1234"
+  }
+}`
+	if string(content) != expJSON {
+		t.Errorf("Expected to get %s, got %s", expJSON, content)
+	}
+
+	leases, err := p.Refresh(client, nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error from Refresh(): %v", err)
+	}
+	if len(leases) != 2 {
+		t.Errorf("Expected %d leases to be shared by all outputs, got %d", 2, len(leases))
+	}
+}
+
+func TestMulti_Write(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(nil, nil)
+	client.PathResults = map[string]*api.Secret{
+		"pki/issue/app": {
+			LeaseID:       "pki/issue/app/abc",
+			LeaseDuration: 3600,
+			Data:          map[string]interface{}{"certificate": "cert", "private_key": "key"},
+		},
+	}
+
+	secrets := []*Secret{{
+		Name:   "TLS",
+		Path:   "pki/issue/app",
+		Fields: []string{"certificate"},
+		Write: map[string]interface{}{
+			"common_name": "app.svc",
+			"alt_names":   map[interface{}]interface{}{"nested": []interface{}{"a"}},
+		},
+	}}
+
+	p := NewMulti(logger, secrets, nil, nil, ValueFormat{}, "")
+	variables, leases, err := p.Variables(client)
+	if err != nil {
+		t.Fatalf("Got unexpected error from Variables(): %v", err)
+	}
+
+	if exp := []string{"TLS_CERTIFICATE=cert"}; !reflect.DeepEqual(exp, variables) {
+		t.Errorf("Expected to get %s, got %s", exp, variables)
+	}
+
+	expWritten := map[string]interface{}{
+		"common_name": "app.svc",
+		"alt_names":   map[string]interface{}{"nested": []interface{}{"a"}},
+	}
+	if !reflect.DeepEqual(expWritten, client.Written["pki/issue/app"]) {
+		t.Errorf("Expected %v to be written, got %v", expWritten, client.Written["pki/issue/app"])
+	}
+
+	if len(leases) != 1 || !strings.HasPrefix(leases[0].Path, "write:pki/issue/app?") {
+		t.Errorf("Expected the lease of the write to be returned, got %+v", leases)
+	}
+}
+
+func TestMulti_ProcessInvalid(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(nil, nil)
+	client.PathResults = map[string]*api.Secret{
+		"secret/api":     {Data: map[string]interface{}{"key": "abcd"}},
+		"secret/missing": nil,
+	}
+
+	tests := map[string][]*Secret{
+		"missing required secret": {{Name: "MISSING", Path: "secret/missing"}},
+		"missing field":           {{Name: "API", Path: "secret/api", Fields: []string{"secret"}}},
+		"duplicate name":          {{Name: "API_KEY", Path: "secret/api"}},
+		"missing name":            {{Path: "secret/api"}},
+		"invalid kv version":      {{Name: "API", Path: "secret/api", KVVersion: 3}},
+		"field and fields":        {{Name: "API", Path: "secret/api", Field: "key", Fields: []string{"key"}}},
+		"write with kv version":   {{Name: "API", Path: "secret/api", KVVersion: 2, Write: map[string]interface{}{}}},
+	}
+
+	for name, secrets := range tests {
+		p := NewMulti(logger, secrets, []string{"SECRET_API_KEY=secret/api#key"}, nil, ValueFormat{}, "")
+		if _, _, err := p.Variables(client); err == nil {
+			t.Errorf("%s: Expected an error, got none", name)
+		}
+	}
+}
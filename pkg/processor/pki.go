@@ -0,0 +1,145 @@
+package processor
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+)
+
+const pkiKeyMode = 0600
+
+// PKI writes the certificates issued by the vault pki secrets engine as PEM files: SECRET_TLS results in
+// TLS/certificate.pem, TLS/private_key.pem, TLS/issuing_ca.pem, TLS/ca_chain.pem and TLS/fullchain.pem, the latter
+// being the certificate followed by its chain. Secrets which are no certificates are ignored.
+// Certificates are not leased, so they are reissued once the given fraction of their lifetime passed instead.
+type PKI struct {
+	logger          *logrus.Entry
+	values          []string
+	files           *Files
+	reissueFraction float64
+	leasesFile      string
+}
+
+// NewPKI returns a new PKI processor instance. The certificate files are written with the given mode, private keys are
+// readable by their owner only.
+func NewPKI(logger *logrus.Entry, env []string, dir string, mode os.FileMode, uid, gid int, reissueFraction float64, leasesFile string) *PKI {
+	files := NewFiles(logger, nil, dir, mode, uid, gid, ValueFormat{}, "")
+	files.modes = map[string]os.FileMode{"private_key.pem": pkiKeyMode}
+
+	return &PKI{
+		logger:          logger,
+		values:          env,
+		files:           files,
+		reissueFraction: reissueFraction,
+		leasesFile:      leasesFile,
+	}
+}
+
+// Process issues the certificates referenced by the SECRET_ env vars and writes them into the PKI directory
+func (p *PKI) Process(logicalClient vaultLogicalClient) error {
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
+
+// Refresh writes the certificate files again, issuing only the certificates not contained in the given leases
+func (p *PKI) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refs, err := parseSecretRefs(p.logger, p.values)
+	if err != nil {
+		return nil, err
+	}
+
+	return refresh(p.logger, logicalClient, leases, refs, p.leasesFile, p)
+}
+
+// render writes the PEM files of the certificates among the given secrets and schedules their reissue
+func (p *PKI) render(reader *secretReader, secrets []*secretValue) error {
+	files := map[string]string{}
+	for _, secret := range secrets {
+		data, ok := secret.data.(map[string]interface{})
+		if !ok || data["certificate"] == nil {
+			p.logger.Debugf("Skipping secret %q as it is no certificate", secret.name)
+			continue
+		}
+
+		pemFiles, notBefore, notAfter, err := certificateFiles(data)
+		if err != nil {
+			return fmt.Errorf("invalid certificate %q: %v", secret.name, err)
+		}
+
+		for name, content := range pemFiles {
+			files[filepath.Join(formatKey(secret.name), name)] = content
+		}
+
+		if l := reader.leaseOf(secret.name); l != nil {
+			rotateAt := notBefore.Add(time.Duration(float64(notAfter.Sub(notBefore)) * p.reissueFraction))
+			l.RotateAt = &rotateAt
+			if l.ExpiresAt == nil {
+				l.ExpiresAt = &notAfter
+			}
+			p.logger.Debugf("Certificate %q expires at %s, reissuing it at %s", secret.name, notAfter.Format(time.RFC3339), rotateAt.Format(time.RFC3339))
+		}
+	}
+
+	if err := p.files.write(files); err != nil {
+		return fmt.Errorf("failed to write certificate files: %v", err)
+	}
+
+	return nil
+}
+
+// certificateFiles returns the PEM files of the given pki secret data along with the validity of its certificate
+func certificateFiles(data map[string]interface{}) (map[string]string, time.Time, time.Time, error) {
+	certificate, _ := data["certificate"].(string)
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil {
+		return nil, time.Time{}, time.Time{}, errors.New("no PEM encoded certificate found")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, time.Time{}, time.Time{}, fmt.Errorf("failed to parse certificate: %v", err)
+	}
+
+	files := map[string]string{"certificate.pem": pemFile(certificate)}
+
+	if key, ok := data["private_key"].(string); ok && key != "" {
+		files["private_key.pem"] = pemFile(key)
+	}
+
+	issuingCA, _ := data["issuing_ca"].(string)
+	if issuingCA != "" {
+		files["issuing_ca.pem"] = pemFile(issuingCA)
+	}
+
+	var chain []string
+	if caChain, ok := data["ca_chain"].([]interface{}); ok {
+		for _, ca := range caChain {
+			if s, ok := ca.(string); ok && s != "" {
+				chain = append(chain, pemFile(s))
+			}
+		}
+	}
+	if len(chain) == 0 && issuingCA != "" {
+		chain = []string{pemFile(issuingCA)}
+	}
+
+	if len(chain) > 0 {
+		files["ca_chain.pem"] = strings.Join(chain, "")
+	}
+	files["fullchain.pem"] = pemFile(certificate) + strings.Join(chain, "")
+
+	return files, cert.NotBefore, cert.NotAfter, nil
+}
+
+// pemFile terminates the given PEM block with a newline, as vault returns them without
+func pemFile(block string) string {
+	return strings.TrimRight(block, "\n") + "\n"
+}
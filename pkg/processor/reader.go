@@ -0,0 +1,247 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"github.com/libri-gmbh/kube-vault/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// secretReader reads secrets for a single processor run. Each secret is read only once, so all fields of a dynamic
+// secret belong to the same lease, even if they are selected separately.
+type secretReader struct {
+	logger        *logrus.Entry
+	logicalClient vaultLogicalClient
+	cache         map[string]*cachedSecret
+	leases        []*lease.Lease
+	// mounts holds the detected mount paths along with whether they are kv version 2 mounts, undetected the paths
+	// whose mount could not be detected, so the mounts are looked up only once per run
+	mounts     map[string]bool
+	undetected map[string]bool
+}
+
+type cachedSecret struct {
+	lease *lease.Lease
+	data  interface{}
+}
+
+// newSecretReader returns a new secretReader instance. The given leases are used instead of reading their secrets
+// again, which allows re-rendering the output with only expiring secrets being read.
+func newSecretReader(logger *logrus.Entry, logicalClient vaultLogicalClient, leases []*lease.Lease) *secretReader {
+	r := &secretReader{
+		logger:        logger,
+		logicalClient: logicalClient,
+		cache:         map[string]*cachedSecret{},
+		mounts:        map[string]bool{},
+		undetected:    map[string]bool{},
+	}
+
+	// only leased secrets and the ones rotated at a given time are reused, as they are the only ones expiring. Static
+	// secrets like kv ones are read again.
+	for _, l := range leases {
+		if l.Path != "" && (l.LeaseID != "" || l.RotateAt != nil) {
+			r.cache[l.Path] = &cachedSecret{lease: l, data: l.Data}
+		}
+	}
+
+	return r
+}
+
+// referenceFunc returns the template secret function, reading the secret referenced by the given string. The secrets
+// are named after the template, so raw paths do not end up in the lease names and metric labels.
+func (r *secretReader) referenceFunc(name string) func(string) (interface{}, error) {
+	return func(reference string) (interface{}, error) {
+		ref, err := parseSecretRef(name, reference)
+		if err != nil {
+			return nil, err
+		}
+
+		return r.read(ref)
+	}
+}
+
+// read returns the data of the secret the given ref points to, with the selected field applied
+func (r *secretReader) read(ref *secretRef) (interface{}, error) {
+	key := ref.readKey()
+	cached, ok := r.cache[key]
+	if !ok {
+		timer := prometheus.NewTimer(metrics.ReadDuration.WithLabelValues(ref.name))
+		secret, data, err := r.readSecret(ref)
+		timer.ObserveDuration()
+		if err != nil {
+			metrics.ReadErrors.WithLabelValues(ref.name).Inc()
+			return nil, err
+		}
+
+		cached = &cachedSecret{lease: lease.NewLease(secret, key), data: data}
+		r.cache[key] = cached
+	}
+
+	if !r.contains(cached.lease) {
+		r.leases = append(r.leases, cached.lease)
+	}
+	cached.lease.AddName(ref.name)
+
+	return ref.apply(cached.data)
+}
+
+// secretValue is the data of a secret read for the given SECRET_ name
+type secretValue struct {
+	name string
+	data interface{}
+}
+
+// readAll reads the secrets of all given refs. Secrets which are optional are skipped if they can not be read.
+func (r *secretReader) readAll(refs []*secretRef) ([]*secretValue, error) {
+	var values []*secretValue
+	for _, ref := range refs {
+		data, err := r.read(ref)
+		if err != nil && ref.optional {
+			r.logger.Warnf("Skipping optional secret %q: %v", ref.name, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, &secretValue{name: ref.name, data: data})
+	}
+
+	return values, nil
+}
+
+// leaseOf returns the lease of the secret read for the given name, nil if none was read
+func (r *secretReader) leaseOf(name string) *lease.Lease {
+	for _, l := range r.leases {
+		for _, n := range l.Names {
+			if n == name {
+				return l
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *secretReader) contains(l *lease.Lease) bool {
+	for _, existing := range r.leases {
+		if existing == l {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readSecret reads the secret the given ref points to and returns it along with the data to be rendered. For kv
+// version 2 mounts the path is rewritten to the data endpoint and the secret data is unwrapped from the response.
+func (r *secretReader) readSecret(ref *secretRef) (*api.Secret, interface{}, error) {
+	if ref.write {
+		r.logger.Debugf("Writing to %q to fetch %q", ref.path, ref.name)
+
+		secret, err := r.logicalClient.Write(ref.path, ref.params)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to write secret endpoint %q: %v", ref.path, err)
+		}
+		if secret == nil {
+			return nil, nil, fmt.Errorf("no secret returned by %q", ref.path)
+		}
+
+		return secret, secret.Data, nil
+	}
+
+	var mountPath string
+	var kv2 bool
+	if !ref.kv1 {
+		mountPath, kv2 = r.mount(ref.path)
+	}
+	if ref.kv2 && !kv2 {
+		// the mount could not be detected, assume the first path segment to be the mount
+		mountPath, kv2 = strings.SplitN(ref.path, "/", 2)[0]+"/", true
+	}
+
+	if !kv2 {
+		if ref.version != "" {
+			return nil, nil, fmt.Errorf("version given for %q, but %q is not a kv version 2 mount", ref.name, ref.path)
+		}
+
+		secret, err := r.logicalClient.Read(ref.path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read secret endpoint %q: %v", ref.path, err)
+		}
+		if secret == nil {
+			return nil, nil, fmt.Errorf("no secret found at %q", ref.path)
+		}
+
+		return secret, secret.Data, nil
+	}
+
+	dataPath := mountPath + "data/" + strings.TrimPrefix(ref.path, mountPath)
+	r.logger.Debugf("Reading %q from kv version 2 mount %q", dataPath, mountPath)
+
+	var params map[string][]string
+	if ref.version != "" {
+		params = map[string][]string{"version": {ref.version}}
+	}
+
+	secret, err := r.logicalClient.ReadWithData(dataPath, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read secret endpoint %q: %v", dataPath, err)
+	}
+	if secret == nil || secret.Data["data"] == nil {
+		return nil, nil, fmt.Errorf("no secret found at %q", dataPath)
+	}
+
+	return secret, secret.Data["data"], nil
+}
+
+// mount returns the mount path the given path belongs to and whether it is a kv version 2 mount. Vault is only asked
+// if the mount of the path was not detected before.
+func (r *secretReader) mount(path string) (string, bool) {
+	for mountPath, kv2 := range r.mounts {
+		if strings.HasPrefix(path, mountPath) {
+			return mountPath, kv2
+		}
+	}
+	if r.undetected[path] {
+		return "", false
+	}
+
+	mountPath, kv2 := detectKV2Mount(r.logger, r.logicalClient, path)
+	if mountPath == "" {
+		r.undetected[path] = true
+		return "", false
+	}
+	r.mounts[mountPath] = kv2
+
+	return mountPath, kv2
+}
+
+// detectKV2Mount asks vault for the mount the given path belongs to, returning the mount path and whether it is a
+// kv version 2 mount. Failures are not fatal, as the token may not be permitted to read the mount information.
+func detectKV2Mount(logger *logrus.Entry, logicalClient vaultLogicalClient, path string) (string, bool) {
+	secret, err := logicalClient.Read("sys/internal/ui/mounts/" + path)
+	if err != nil || secret == nil {
+		logger.Debugf("Unable to detect mount of %q, assuming kv version 1: %v", path, err)
+		return "", false
+	}
+
+	mountPath, _ := secret.Data["path"].(string)
+	if mountPath == "" || !strings.HasSuffix(mountPath, "/") {
+		return "", false
+	}
+
+	mountType, _ := secret.Data["type"].(string)
+	options, _ := secret.Data["options"].(map[string]interface{})
+	if mountType != "kv" || options == nil {
+		return mountPath, false
+	}
+
+	version, _ := options["version"].(string)
+
+	return mountPath, version == "2"
+}
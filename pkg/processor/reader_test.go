@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func TestSecretReader_MountLookups(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	kv2Mount := &api.Secret{
+		Data: map[string]interface{}{
+			"path":    "kv/",
+			"type":    "kv",
+			"options": map[string]interface{}{"version": "2"},
+		},
+	}
+	kv2Data := &api.Secret{Data: map[string]interface{}{"data": map[string]interface{}{"password": "test5678"}}}
+	client := internalTesting.NewVaultClientLogical(&api.Secret{Data: map[string]interface{}{"password": "test1234"}}, nil)
+	client.PathResults = map[string]*api.Secret{
+		"sys/internal/ui/mounts/kv/app/db":    kv2Mount,
+		"sys/internal/ui/mounts/kv/app/cache": kv2Mount,
+		"sys/internal/ui/mounts/database/creds/app": {
+			Data: map[string]interface{}{"path": "database/", "type": "database"},
+		},
+		"sys/internal/ui/mounts/secret/app": nil,
+		"kv/data/app/db":                    kv2Data,
+		"kv/data/app/cache":                 kv2Data,
+	}
+
+	reader := newSecretReader(logger, client, nil)
+	for _, reference := range []string{
+		"kv/app/db", "kv/app/db?version=3", "kv/app/cache", "database/creds/app", "database/creds/app/other",
+		"secret/app", "secret/app#password",
+	} {
+		ref, err := parseSecretRef("SECRET", reference)
+		if err != nil {
+			t.Fatalf("Got unexpected error from parseSecretRef(): %v", err)
+		}
+		if _, err := reader.read(ref); err != nil {
+			t.Fatalf("Got unexpected error from read() for %q: %v", reference, err)
+		}
+	}
+
+	expected := map[string]int{
+		"sys/internal/ui/mounts/kv/app/db":         1,
+		"sys/internal/ui/mounts/database/creds/app": 1,
+		"sys/internal/ui/mounts/secret/app":         1,
+	}
+	lookups := 0
+	for path, reads := range client.Reads {
+		if strings.HasPrefix(path, "sys/internal/ui/mounts/") {
+			lookups++
+			if reads != expected[path] {
+				t.Errorf("Expected %d mount lookups of %q, got %d", expected[path], path, reads)
+			}
+		}
+	}
+	if lookups != len(expected) {
+		t.Errorf("Expected the mounts to be looked up for %d paths, got %v", len(expected), client.Reads)
+	}
+}
@@ -0,0 +1,224 @@
+package processor
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	envPrefix   = "SECRET_"
+	kv1Marker   = "kv1:"
+	kv2Marker   = "kv2:"
+	writeMarker = "write:"
+)
+
+// secretRef is a reference to a vault secret as given in the value of a SECRET_ env var, e.g.
+// "kv2:kv/app/db?version=3#password", or by a secret of the config file. Secrets of endpoints which need parameters,
+// like "write:pki/issue/app?common_name=app.example.com", are fetched by a write instead of a read.
+type secretRef struct {
+	name    string
+	path    string
+	kv1     bool
+	kv2     bool
+	version string
+	field   string
+	write   bool
+	params  map[string]interface{}
+	// fields, rename and optional can only be given using the config file
+	fields   []string
+	rename   map[string]string
+	optional bool
+}
+
+// parseSecretRefs parses all SECRET_ prefixed variables of the given env into secretRefs
+func parseSecretRefs(logger *logrus.Entry, env []string) ([]*secretRef, error) {
+	var refs []*secretRef
+
+	for _, envVar := range env {
+		if !strings.HasPrefix(envVar, envPrefix) {
+			logger.Debugf("Skipping %q, not prefixed with SECRET_", strings.Split(envVar, "=")[0])
+			continue
+		}
+
+		envVarName, uri := splitAndCleanEnv(envVar)
+		logger.Debugf("Loading env var %q from %q", envVarName, uri)
+
+		ref, err := parseSecretRef(envVarName, uri)
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, ref)
+	}
+
+	return refs, nil
+}
+
+// splitAndCleanEnv receives an env var and splits it into key and value, which gets trimmed
+// prefixes and slashes respectively
+func splitAndCleanEnv(env string) (string, string) {
+	parts := strings.SplitN(env, "=", 2)
+	return strings.Replace(parts[0], envPrefix, "", 1), strings.Trim(parts[1], "/")
+}
+
+// parseSecretRef parses the cleaned value of a SECRET_ env var into a secretRef
+func parseSecretRef(name, value string) (*secretRef, error) {
+	ref := &secretRef{name: name}
+
+	if strings.HasPrefix(value, writeMarker) {
+		ref.write = true
+		value = strings.TrimPrefix(value, writeMarker)
+	}
+
+	if strings.HasPrefix(value, kv2Marker) {
+		ref.kv2 = true
+		value = strings.TrimPrefix(value, kv2Marker)
+	}
+
+	if parts := strings.SplitN(value, "#", 2); len(parts) == 2 {
+		if parts[1] == "" {
+			return nil, fmt.Errorf("empty field selector given for %q", name)
+		}
+		value, ref.field = parts[0], parts[1]
+	}
+
+	parts := strings.SplitN(value, "?", 2)
+	ref.path = strings.Trim(parts[0], "/")
+	if ref.path == "" {
+		return nil, fmt.Errorf("no secret path given for %q", name)
+	}
+
+	if len(parts) == 1 {
+		return ref, nil
+	}
+
+	query, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse parameters of %q: %v", name, err)
+	}
+
+	if ref.write {
+		ref.params = make(map[string]interface{}, len(query))
+		for key := range query {
+			ref.params[key] = query.Get(key)
+		}
+		return ref, nil
+	}
+
+	for key := range query {
+		if key != "version" {
+			return nil, fmt.Errorf("unknown parameter %q given for %q", key, name)
+		}
+	}
+
+	if version := query.Get("version"); version != "" {
+		if _, err := strconv.Atoi(version); err != nil {
+			return nil, fmt.Errorf("invalid version %q given for %q", version, name)
+		}
+		ref.version = version
+	}
+
+	return ref, nil
+}
+
+// readKey identifies the vault read of the ref, ignoring the selected field
+func (r *secretRef) readKey() string {
+	if r.write {
+		return writeMarker + r.path + encodeParams(r.params)
+	}
+
+	key := r.path
+	if r.kv1 {
+		key = kv1Marker + key
+	}
+	if r.kv2 {
+		key = kv2Marker + key
+	}
+	if r.version != "" {
+		key += "?version=" + r.version
+	}
+
+	return key
+}
+
+// encodeParams encodes write parameters as sorted query, e.g. "?common_name=app.example.com&ttl=24h"
+func encodeParams(params map[string]interface{}) string {
+	if len(params) == 0 {
+		return ""
+	}
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, fmt.Sprint(value))
+	}
+
+	return "?" + query.Encode()
+}
+
+// apply returns the given secret data with the selected fields and renamed keys of the ref applied
+func (r *secretRef) apply(data interface{}) (interface{}, error) {
+	data, err := r.selectField(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.fields) == 0 && len(r.rename) == 0 {
+		return data, nil
+	}
+
+	values, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("fields or renamed keys given for %q, but the secret %q is not an object", r.name, r.path)
+	}
+
+	if len(r.fields) > 0 {
+		selected := make(map[string]interface{}, len(r.fields))
+		for _, field := range r.fields {
+			value, err := (&secretRef{name: r.name, path: r.path, field: field}).selectField(values)
+			if err != nil {
+				return nil, err
+			}
+			selected[field] = value
+		}
+		values = selected
+	}
+
+	renamed := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		if newKey, ok := r.rename[key]; ok {
+			key = newKey
+		}
+		renamed[key] = value
+	}
+
+	return renamed, nil
+}
+
+// selectField returns the value of the selected field of the given secret data, walking down nested objects for
+// dotted selectors like "data.password". The data is returned unchanged if no field was selected.
+func (r *secretRef) selectField(data interface{}) (interface{}, error) {
+	if r.field == "" {
+		return data, nil
+	}
+
+	for _, key := range strings.Split(r.field, ".") {
+		var ok bool
+
+		switch values := data.(type) {
+		case map[string]interface{}:
+			data, ok = values[key]
+		case map[string]string:
+			data, ok = values[key]
+		}
+
+		if !ok {
+			return nil, fmt.Errorf("field %q selected for %q not found in secret %q", r.field, r.name, r.path)
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,100 @@
+package processor
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSecretRef(t *testing.T) {
+	tests := []struct {
+		value string
+		exp   *secretRef
+	}{
+		{"secrets/asdf/qwertz", &secretRef{name: "ASDF", path: "secrets/asdf/qwertz"}},
+		{"kv2:kv/app/db", &secretRef{name: "ASDF", path: "kv/app/db", kv2: true}},
+		{"kv/app/db?version=3", &secretRef{name: "ASDF", path: "kv/app/db", version: "3"}},
+		{"kv2:/kv/app/db/?version=3", &secretRef{name: "ASDF", path: "kv/app/db", kv2: true, version: "3"}},
+		{"database/creds/app#password", &secretRef{name: "ASDF", path: "database/creds/app", field: "password"}},
+		{"kv/app/db?version=3#data.password", &secretRef{name: "ASDF", path: "kv/app/db", version: "3", field: "data.password"}},
+		{"write:aws/sts/app", &secretRef{name: "ASDF", path: "aws/sts/app", write: true}},
+		{"write:pki/issue/app?common_name=app.svc&ttl=24h#certificate", &secretRef{
+			name:   "ASDF",
+			path:   "pki/issue/app",
+			field:  "certificate",
+			write:  true,
+			params: map[string]interface{}{"common_name": "app.svc", "ttl": "24h"},
+		}},
+	}
+
+	for _, test := range tests {
+		ref, err := parseSecretRef("ASDF", test.value)
+		if err != nil {
+			t.Errorf("Got unexpected error for %q: %v", test.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(test.exp, ref) {
+			t.Errorf("Expected to get %+v for %q, got %+v", test.exp, test.value, ref)
+		}
+	}
+}
+
+func TestParseSecretRefInvalid(t *testing.T) {
+	for _, value := range []string{"", "kv2:", "kv/app/db?version=latest", "kv/app/db?foo=bar", "kv/app/db#"} {
+		if _, err := parseSecretRef("ASDF", value); err == nil {
+			t.Errorf("Expected an error for %q, got none", value)
+		}
+	}
+}
+
+func TestSecretRef_ReadKey(t *testing.T) {
+	tests := map[string]*secretRef{
+		"kv/app/db":     {path: "kv/app/db"},
+		"kv1:kv/app/db": {path: "kv/app/db", kv1: true},
+		"write:pki/issue/app?common_name=app.svc&ttl=24h": {
+			path:   "pki/issue/app",
+			write:  true,
+			params: map[string]interface{}{"ttl": "24h", "common_name": "app.svc"},
+		},
+	}
+
+	for exp, ref := range tests {
+		if res := ref.readKey(); res != exp {
+			t.Errorf("Expected read key %q, got %q", exp, res)
+		}
+	}
+}
+
+func TestSecretRef_SelectField(t *testing.T) {
+	data := map[string]interface{}{
+		"username": "test1234",
+		"data": map[string]interface{}{
+			"password": "test5678",
+		},
+	}
+
+	tests := map[string]interface{}{
+		"":              data,
+		"username":      "test1234",
+		"data":          data["data"],
+		"data.password": "test5678",
+	}
+
+	for field, exp := range tests {
+		ref := &secretRef{name: "ASDF", path: "secrets/asdf", field: field}
+		res, err := ref.selectField(data)
+		if err != nil {
+			t.Errorf("Got unexpected error for field %q: %v", field, err)
+			continue
+		}
+		if !reflect.DeepEqual(exp, res) {
+			t.Errorf("Expected to get %v for field %q, got %v", exp, field, res)
+		}
+	}
+
+	for _, field := range []string{"password", "data.username", "username.length"} {
+		ref := &secretRef{name: "ASDF", path: "secrets/asdf", field: field}
+		if _, err := ref.selectField(data); err == nil {
+			t.Errorf("Expected an error for missing field %q, got none", field)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Secret configures a vault secret to be fetched, as given in the config file or a VaultSecret resource. It offers the
+// options of the SECRET_ env vars along with the ones which can not be expressed in them.
+type Secret struct {
+	// Name is used like the name of a SECRET_ env var, e.g. as prefix of the rendered env vars
+	Name string `yaml:"name" json:"name,omitempty"`
+	Path string `yaml:"path" json:"path,omitempty"`
+	// KVVersion forces the kv version of the mount, which is detected if zero
+	KVVersion int `yaml:"kv_version" json:"kv_version,omitempty"`
+	// Version pins the version of a kv version 2 secret
+	Version int `yaml:"version" json:"version,omitempty"`
+	// Field selects a single field, like the "#field" suffix of SECRET_ env vars
+	Field string `yaml:"field" json:"field,omitempty"`
+	// Fields selects a subset of the fields of the secret
+	Fields []string `yaml:"fields" json:"fields,omitempty"`
+	// Rename maps field names to the keys they are rendered with
+	Rename map[string]string `yaml:"rename" json:"rename,omitempty"`
+	// Optional secrets are skipped if they can not be read, instead of failing
+	Optional bool `yaml:"optional" json:"optional,omitempty"`
+	// Write fetches the secret by writing the given parameters to the path instead of reading it, e.g. to issue a
+	// certificate. An empty map writes without parameters.
+	Write map[string]interface{} `yaml:"write" json:"write,omitempty"`
+}
+
+// ref validates the secret and converts it into a secretRef
+func (s *Secret) ref() (*secretRef, error) {
+	if s.Name == "" {
+		return nil, fmt.Errorf("no name given for secret %q", s.Path)
+	}
+
+	ref := &secretRef{
+		name:     s.Name,
+		path:     strings.Trim(s.Path, "/"),
+		field:    s.Field,
+		fields:   s.Fields,
+		rename:   s.Rename,
+		optional: s.Optional,
+	}
+
+	if ref.path == "" {
+		return nil, fmt.Errorf("no secret path given for %q", s.Name)
+	}
+
+	switch s.KVVersion {
+	case 0:
+	case 1:
+		ref.kv1 = true
+	case 2:
+		ref.kv2 = true
+	default:
+		return nil, fmt.Errorf("invalid kv version %d given for %q", s.KVVersion, s.Name)
+	}
+
+	if s.Version != 0 {
+		if ref.kv1 {
+			return nil, fmt.Errorf("version given for %q, but kv version 1 is forced", s.Name)
+		}
+		ref.version = strconv.Itoa(s.Version)
+	}
+
+	if s.Field != "" && len(s.Fields) > 0 {
+		return nil, fmt.Errorf("both field and fields given for %q", s.Name)
+	}
+
+	if s.Write != nil {
+		if s.KVVersion != 0 || s.Version != 0 {
+			return nil, fmt.Errorf("kv options given for %q, but it is fetched by a write", s.Name)
+		}
+
+		params, ok := stringKeys(s.Write).(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid write parameters given for %q", s.Name)
+		}
+		ref.write, ref.params = true, params
+	}
+
+	return ref, nil
+}
+
+// secretRefs converts the given secrets into secretRefs, along with the ones of the SECRET_ env vars of the given env
+func secretRefs(logger *logrus.Entry, secrets []*Secret, env []string) ([]*secretRef, error) {
+	var refs []*secretRef
+	for _, secret := range secrets {
+		ref, err := secret.ref()
+		if err != nil {
+			return nil, err
+		}
+
+		refs = append(refs, ref)
+	}
+
+	envRefs, err := parseSecretRefs(logger, env)
+	if err != nil {
+		return nil, err
+	}
+	refs = append(refs, envRefs...)
+
+	names := map[string]bool{}
+	for _, ref := range refs {
+		if names[ref.name] {
+			return nil, fmt.Errorf("secret %q is configured more than once", ref.name)
+		}
+		names[ref.name] = true
+	}
+
+	return refs, nil
+}
@@ -0,0 +1,120 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	formatJSON = "json"
+	formatYAML = "yaml"
+)
+
+// Structured writes the secrets referenced by SECRET_ env vars into a single json or yaml file. Other than the Env
+// processor it keeps the nested shape of the secret data, stored under the SECRET_ name.
+type Structured struct {
+	logger     *logrus.Entry
+	values     []string
+	format     string
+	file       string
+	leasesFile string
+}
+
+// NewJSON returns a new Structured processor instance writing a json file
+func NewJSON(logger *logrus.Entry, env []string, file, leasesFile string) *Structured {
+	return newStructured(logger, env, formatJSON, file, leasesFile)
+}
+
+// NewYAML returns a new Structured processor instance writing a yaml file
+func NewYAML(logger *logrus.Entry, env []string, file, leasesFile string) *Structured {
+	return newStructured(logger, env, formatYAML, file, leasesFile)
+}
+
+func newStructured(logger *logrus.Entry, env []string, format, file, leasesFile string) *Structured {
+	return &Structured{
+		logger:     logger,
+		values:     env,
+		format:     format,
+		file:       file,
+		leasesFile: leasesFile,
+	}
+}
+
+// Process fetches the secrets referenced by the SECRET_ env vars and stores them in the configured format
+func (p *Structured) Process(logicalClient vaultLogicalClient) error {
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
+
+// Refresh writes the secrets file again, fetching only the secrets not contained in the given leases
+func (p *Structured) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	refs, err := parseSecretRefs(p.logger, p.values)
+	if err != nil {
+		return nil, err
+	}
+
+	return refresh(p.logger, logicalClient, leases, refs, p.leasesFile, p)
+}
+
+// render writes the given secrets into the secrets file, keyed by their SECRET_ names
+func (p *Structured) render(reader *secretReader, secrets []*secretValue) error {
+	values := map[string]interface{}{}
+	for _, secret := range secrets {
+		values[secret.name] = secret.data
+	}
+
+	content, err := p.marshal(values)
+	if err != nil {
+		return fmt.Errorf("failed to encode secrets as %s: %v", p.format, err)
+	}
+
+	if err := writeFile(content, p.file); err != nil {
+		return fmt.Errorf("failed to write secrets file: %v", err)
+	}
+
+	return nil
+}
+
+func (p *Structured) marshal(values map[string]interface{}) ([]byte, error) {
+	if p.format == formatYAML {
+		return yaml.Marshal(normalizeNumbers(values))
+	}
+
+	return json.MarshalIndent(values, "", "  ")
+}
+
+// normalizeNumbers converts the json.Number values returned by the vault client into ints or floats, as yaml would
+// encode them as strings otherwise
+func normalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			values[key] = normalizeNumbers(nested)
+		}
+		return values
+
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, nested := range v {
+			values[i] = normalizeNumbers(nested)
+		}
+		return values
+
+	default:
+		return value
+	}
+}
@@ -0,0 +1,73 @@
+package processor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func TestStructured_Process(t *testing.T) {
+	secret := &api.Secret{
+		Data: map[string]interface{}{
+			"username": "test1234",
+			"ttl":      json.Number("3600"),
+			"endpoint": map[string]interface{}{
+				"url": "http://asdf.net/",
+			},
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+	client := internalTesting.NewVaultClientLogical(secret, nil)
+
+	file, fileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer fileCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	env := []string{"SECRET_ASDF=secrets/asdf", "SECRET_USER=secrets/asdf#username"}
+	tests := map[*Structured]string{
+		NewJSON(logger, env, file, leasesFile): `{
+  "ASDF": {
+    "endpoint": {
+      "url": "http://asdf.net/"
+    },
+    "ttl": 3600,
+    "username": "test1234"
+  },
+  "USER": "test1234"
+}`,
+		NewYAML(logger, env, file, leasesFile): `ASDF:
+  endpoint:
+    url: http://asdf.net/
+  ttl: 3600
+  username: test1234
+USER: test1234
+`,
+	}
+
+	for p, exp := range tests {
+		if err := p.Process(client); err != nil {
+			t.Fatalf("Got unexpected error from %s Process(): %v", p.format, err)
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read written file: %v", err)
+		}
+
+		if string(content) != exp {
+			t.Errorf("Expected to get %s for format %s, got %s", exp, p.format, string(content))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+)
+
+// nonKeyCharacters matches the characters replaced when deriving the secret name of a template
+var nonKeyCharacters = regexp.MustCompile(`[^A-Za-z0-9]+`)
+
+// Template renders go text/template files, which read vault secrets using the secret function, e.g.
+// {{ with secret "database/creds/app" }}{{ .username }}{{ end }}
+type Template struct {
+	logger     *logrus.Entry
+	templates  []string
+	leasesFile string
+}
+
+// NewTemplate returns a new Template processor instance. Each template is given as "source:destination" pair.
+func NewTemplate(logger *logrus.Entry, templates []string, leasesFile string) *Template {
+	return &Template{
+		logger:     logger,
+		templates:  templates,
+		leasesFile: leasesFile,
+	}
+}
+
+// Process renders all configured templates, storing the secrets read by them in the leases file
+func (p *Template) Process(logicalClient vaultLogicalClient) error {
+	_, err := p.Refresh(logicalClient, nil)
+	return err
+}
+
+// Refresh renders the templates again, fetching only the secrets not contained in the given leases
+func (p *Template) Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error) {
+	return refresh(p.logger, logicalClient, leases, nil, p.leasesFile, p)
+}
+
+// render renders all templates, the secrets are read by the templates themselves using the given reader
+func (p *Template) render(reader *secretReader, secrets []*secretValue) error {
+	if len(p.templates) == 0 {
+		return fmt.Errorf("no templates given to render")
+	}
+
+	for _, tpl := range p.templates {
+		parts := strings.SplitN(tpl, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("invalid template %q, expected source:destination", tpl)
+		}
+
+		if err := p.renderTemplate(reader, parts[0], parts[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Template) renderTemplate(reader *secretReader, source, destination string) error {
+	p.logger.Debugf("Rendering template %q to %q", source, destination)
+
+	tpl, err := template.New(filepath.Base(source)).
+		Option("missingkey=error").
+		Funcs(template.FuncMap{"secret": reader.referenceFunc(templateSecretName(source))}).
+		ParseFiles(source)
+	if err != nil {
+		return fmt.Errorf("failed to parse template %q: %v", source, err)
+	}
+
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, nil); err != nil {
+		return fmt.Errorf("failed to render template %q: %v", source, err)
+	}
+
+	if err := writeFile(b.Bytes(), destination); err != nil {
+		return fmt.Errorf("failed to write rendered template: %v", err)
+	}
+
+	return nil
+}
+
+// templateSecretName returns the name of the secrets read by the given template, e.g. APP_CONF for /templates/app.conf
+func templateSecretName(source string) string {
+	return strings.Trim(strings.ToUpper(nonKeyCharacters.ReplaceAllString(filepath.Base(source), "_")), "_")
+}
@@ -0,0 +1,118 @@
+package processor
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+)
+
+func TestTemplate_Process(t *testing.T) {
+	client := internalTesting.NewVaultClientLogical(nil, nil)
+	client.PathResults = map[string]*api.Secret{
+		"database/creds/app": {
+			LeaseID: "database/creds/app/1234",
+			Data: map[string]interface{}{
+				"username": "test1234",
+				"password": "test5678",
+			},
+		},
+	}
+
+	_, logger := internalTesting.NewLogger()
+
+	source, sourceCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create template file: %v", err)
+	}
+	defer sourceCleanup()
+
+	destination, destinationCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create destination file: %v", err)
+	}
+	defer destinationCleanup()
+
+	leasesFile, leasesFileCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create leasesFile: %v", err)
+	}
+	defer leasesFileCleanup()
+
+	tpl := `username: {{ with secret "database/creds/app" }}{{ .username }}{{ end }}
+password: {{ secret "database/creds/app#password" }}`
+	if err := ioutil.WriteFile(source, []byte(tpl), 0600); err != nil {
+		t.Fatalf("failed to write template file: %v", err)
+	}
+
+	p := NewTemplate(logger, []string{source + ":" + destination}, leasesFile)
+	if err := p.Process(client); err != nil {
+		t.Fatalf("Got unexpected error from Process(): %v", err)
+	}
+
+	content, err := ioutil.ReadFile(destination)
+	if err != nil {
+		t.Fatalf("failed to read rendered template: %v", err)
+	}
+
+	exp := "username: test1234\npassword: test5678"
+	if string(content) != exp {
+		t.Errorf("Expected to get %q, got %q", exp, string(content))
+	}
+
+	bLeases, err := ioutil.ReadFile(leasesFile)
+	if err != nil {
+		t.Fatalf("failed to read written leases file: %v", err)
+	}
+
+	var leases []*lease.Lease
+	if err := json.Unmarshal(bLeases, &leases); err != nil {
+		t.Fatalf("failed to unmarshal json lease file content: %v", err)
+	}
+
+	if len(leases) != 1 {
+		t.Fatalf("Invalid amount of leases, expected %d, got %d", 1, len(leases))
+	}
+
+	expName := templateSecretName(source)
+	if len(leases[0].Names) != 1 || leases[0].Names[0] != expName {
+		t.Errorf("Expected the secret to be named after the template %q, got %v", expName, leases[0].Names)
+	}
+}
+
+func TestTemplate_SecretName(t *testing.T) {
+	tests := map[string]string{
+		"/templates/app.conf":         "APP_CONF",
+		"config.yaml.tpl":             "CONFIG_YAML_TPL",
+		"/tmp/.kube-vault-template-1": "KUBE_VAULT_TEMPLATE_1",
+	}
+
+	for source, exp := range tests {
+		if name := templateSecretName(source); name != exp {
+			t.Errorf("Expected name %q for %q, got %q", exp, source, name)
+		}
+	}
+}
+
+func TestTemplate_ProcessMissingField(t *testing.T) {
+	client := internalTesting.NewVaultClientLogical(&api.Secret{Data: map[string]interface{}{}}, nil)
+	_, logger := internalTesting.NewLogger()
+
+	source, sourceCleanup, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatalf("failed to create template file: %v", err)
+	}
+	defer sourceCleanup()
+
+	if err := ioutil.WriteFile(source, []byte(`{{ secret "secrets/asdf#password" }}`), 0600); err != nil {
+		t.Fatalf("failed to write template file: %v", err)
+	}
+
+	p := NewTemplate(logger, []string{source + ":" + source}, source)
+	if err := p.Process(client); err == nil {
+		t.Errorf("Expected an error for a missing field, got none")
+	}
+}
@@ -1,15 +1,33 @@
 package processor
 
 import (
-	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
 )
 
-// Processor processes env var requirements of an application and renders the result
+// Processor processes the secret requirements of an application and renders the result
 type Processor interface {
-	Process(logger *logrus.Entry, client vaultLogicalClient) error
+	// Process fetches the referenced secrets and renders the result
+	Process(logicalClient vaultLogicalClient) error
+	// Refresh renders the result again, reusing the secrets of the given leases and fetching all others. It returns
+	// the leases of all rendered secrets.
+	Refresh(logicalClient vaultLogicalClient, leases []*lease.Lease) ([]*lease.Lease, error)
+}
+
+// Output is a processor which is able to render secrets read by another processor run, so several outputs can be
+// rendered from a single read of the secrets using the Multi processor
+type Output interface {
+	Processor
+	render(reader *secretReader, secrets []*secretValue) error
+}
+
+// Observer is notified of the secrets rendered by a processor run, keyed by their SECRET_ names
+type Observer interface {
+	Update(secrets map[string]interface{})
 }
 
 type vaultLogicalClient interface {
 	Read(path string) (*api.Secret, error)
+	ReadWithData(path string, data map[string][]string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
 }
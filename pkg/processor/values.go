@@ -0,0 +1,182 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultListSeparator = ","
+
+// ValueFormat configures how the env and files outputs convert secret values into strings. The zero value joins
+// lists using commas and flattens nested objects.
+type ValueFormat struct {
+	// ListSeparator joins the elements of lists of scalar values, defaults to ","
+	ListSeparator string
+	// NestedJSON renders nested objects as json strings instead of flattening them into a key per value
+	NestedJSON bool
+}
+
+// flattenValues walks the given secret data and returns all contained values keyed by their upper cased path,
+// e.g. {"endpoint": {"url": "http://asdf.net/"}} with key ASDF becomes ASDF_ENDPOINT_URL. If key is empty the keys
+// are relative to the given data.
+// Values are converted by the json type they were decoded from: numbers and booleans are formatted as is, lists of
+// scalar values are joined using the list separator and lists containing objects or lists are rendered as json.
+// Values which can not be converted are skipped with a warning.
+func flattenValues(logger *logrus.Entry, format ValueFormat, key string, secret interface{}) map[string]string {
+	values := map[string]string{}
+	format.flatten(logger, values, key, secret, 0)
+
+	return values
+}
+
+func (f ValueFormat) flatten(logger *logrus.Entry, values map[string]string, key string, secret interface{}, depth int) {
+	secretValue := reflect.ValueOf(secret)
+	if secret == nil || (secretValue.Kind() == reflect.Ptr && secretValue.IsNil()) {
+		logger.Debugf("Skipping %q as its value is nil", key)
+		return
+	}
+
+	// the secret itself is always flattened, so its fields are rendered separately even if nested objects are not
+	if secretValue.Kind() == reflect.Map && (depth == 0 || !f.NestedJSON) {
+		for _, mapKey := range secretValue.MapKeys() {
+			nestedKey := formatKey(key, fmt.Sprint(mapKey.Interface()))
+			f.flatten(logger, values, nestedKey, secretValue.MapIndex(mapKey).Interface(), depth+1)
+		}
+		return
+	}
+
+	value, err := f.convert(secret)
+	if err != nil {
+		logger.Warnf("Skipping secret value %q: %v", key, err)
+		return
+	}
+
+	values[formatKey(key)] = value
+}
+
+// convert converts a single secret value into a string
+func (f ValueFormat) convert(secret interface{}) (string, error) {
+	switch v := secret.(type) {
+	case string:
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	}
+
+	secretValue := reflect.ValueOf(secret)
+	switch secretValue.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(secretValue.Int(), 10), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(secretValue.Uint(), 10), nil
+
+	case reflect.Slice, reflect.Array:
+		elements := make([]string, secretValue.Len())
+		for i := range elements {
+			element := secretValue.Index(i).Interface()
+			if element == nil {
+				continue
+			}
+			if composite(element) {
+				return f.marshal(secret)
+			}
+
+			value, err := f.convert(element)
+			if err != nil {
+				return "", err
+			}
+			elements[i] = value
+		}
+
+		return strings.Join(elements, f.listSeparator()), nil
+
+	case reflect.Map:
+		return f.marshal(secret)
+
+	default:
+		return "", fmt.Errorf("unsupported type %T", secret)
+	}
+}
+
+func (f ValueFormat) marshal(secret interface{}) (string, error) {
+	b, err := json.Marshal(stringKeys(secret))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode value as json: %v", err)
+	}
+
+	return string(b), nil
+}
+
+// stringKeys converts the maps decoded by yaml, which are keyed by interface{}, into maps keyed by strings as
+// required to encode them as json
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			converted[fmt.Sprint(key)] = stringKeys(nested)
+		}
+		return converted
+
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, nested := range v {
+			converted[key] = stringKeys(nested)
+		}
+		return converted
+
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, nested := range v {
+			converted[i] = stringKeys(nested)
+		}
+		return converted
+
+	default:
+		return value
+	}
+}
+
+func (f ValueFormat) listSeparator() string {
+	if f.ListSeparator == "" {
+		return defaultListSeparator
+	}
+
+	return f.ListSeparator
+}
+
+// composite returns whether the given value is an object or a list
+func composite(value interface{}) bool {
+	switch reflect.ValueOf(value).Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	default:
+		return false
+	}
+}
+
+// formatKey joins the given non empty pieces of a key and converts it to upper case
+func formatKey(values ...string) string {
+	var pieces []string
+	for _, value := range values {
+		if value != "" {
+			pieces = append(pieces, value)
+		}
+	}
+
+	return strings.ToUpper(strings.Join(pieces, "_"))
+}
@@ -0,0 +1,63 @@
+package retry
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault/api"
+)
+
+type vaultLogicalClient interface {
+	Read(path string) (*api.Secret, error)
+	ReadWithData(path string, data map[string][]string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+// Logical wraps a vault logical client, retrying failed calls using the given policy until the context is done
+type Logical struct {
+	ctx    context.Context
+	policy *Policy
+	client vaultLogicalClient
+}
+
+// NewLogical returns a new Logical instance
+func NewLogical(ctx context.Context, policy *Policy, client vaultLogicalClient) *Logical {
+	return &Logical{
+		ctx:    ctx,
+		policy: policy,
+		client: client,
+	}
+}
+
+// Read reads the secret at the given path
+func (l *Logical) Read(path string) (*api.Secret, error) {
+	return l.ReadWithData(path, nil)
+}
+
+// ReadWithData reads the secret at the given path, passing the given data as query parameters
+func (l *Logical) ReadWithData(path string, data map[string][]string) (*api.Secret, error) {
+	var secret *api.Secret
+	err := l.policy.Do(l.ctx, "read "+path, func() error {
+		var err error
+		if data == nil {
+			secret, err = l.client.Read(path)
+		} else {
+			secret, err = l.client.ReadWithData(path, data)
+		}
+		return err
+	})
+
+	return secret, err
+}
+
+// Write writes the given data to the given path, returning the secret of the response. Writes may not be idempotent,
+// e.g. issuing a certificate, so they are retried only if the request did not reach vault.
+func (l *Logical) Write(path string, data map[string]interface{}) (*api.Secret, error) {
+	var secret *api.Secret
+	err := l.policy.DoUnsent(l.ctx, "write "+path, func() error {
+		var err error
+		secret, err = l.client.Write(path, data)
+		return err
+	})
+
+	return secret, err
+}
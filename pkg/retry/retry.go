@@ -0,0 +1,149 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/hashicorp/vault/api"
+)
+
+// Policy retries failed vault calls with an exponential backoff. Only network errors and responses with one of the
+// retryable status codes are retried, any other error is returned right away.
+type Policy struct {
+	logger         *logrus.Entry
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+	jitter         float64
+	retryableCodes []int
+
+	mu     sync.Mutex
+	random *rand.Rand
+}
+
+// NewPolicy returns a new Policy instance. The delay before a retry starts at baseDelay and is doubled for each
+// attempt up to maxDelay, while jitter is the fraction of the delay which is randomly subtracted.
+func NewPolicy(logger *logrus.Entry, maxAttempts int, baseDelay, maxDelay time.Duration, jitter float64, retryableCodes []int) *Policy {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 1 {
+		jitter = 1
+	}
+
+	return &Policy{
+		logger:         logger,
+		maxAttempts:    maxAttempts,
+		baseDelay:      baseDelay,
+		maxDelay:       maxDelay,
+		jitter:         jitter,
+		retryableCodes: retryableCodes,
+		random:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Do calls fn until it succeeds, fails with an error which is not retryable, runs out of attempts or the context is
+// done, returning the last error. The description is used for logging.
+func (p *Policy) Do(ctx context.Context, description string, fn func() error) error {
+	return p.do(ctx, description, p.Retryable, fn)
+}
+
+// DoUnsent is like Do, but retries only calls which failed before the request was sent to vault. It is used for calls
+// which must not be repeated, like writes issuing a new certificate.
+func (p *Policy) DoUnsent(ctx context.Context, description string, fn func() error) error {
+	return p.do(ctx, description, unsent, fn)
+}
+
+func (p *Policy) do(ctx context.Context, description string, retryable func(error) bool, fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= p.maxAttempts; attempt++ {
+		err = fn()
+		if err == nil || !retryable(err) || attempt == p.maxAttempts {
+			return err
+		}
+
+		delay := p.Delay(attempt)
+		p.logger.Warnf("failed to %s (attempt %d of %d), retrying in %v: %v", description, attempt, p.maxAttempts, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+
+	return err
+}
+
+// Delay returns how long to wait after the given failed attempt, starting at 1
+func (p *Policy) Delay(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < p.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.maxDelay {
+		delay = p.maxDelay
+	}
+
+	p.mu.Lock()
+	random := p.random.Float64()
+	p.mu.Unlock()
+
+	return delay - time.Duration(float64(delay)*p.jitter*random)
+}
+
+// Retryable returns whether a call failing with the given error should be tried again
+func (p *Policy) Retryable(err error) bool {
+	if code, ok := StatusCode(err); ok {
+		for _, retryable := range p.retryableCodes {
+			if code == retryable {
+				return true
+			}
+		}
+		return false
+	}
+
+	return networkError(err)
+}
+
+// StatusCode returns the http status code of an error returned by the vault api client, the second return value is
+// false if the error is not a response error
+func StatusCode(err error) (int, bool) {
+	responseErr, ok := err.(*api.ResponseError)
+	if !ok {
+		return 0, false
+	}
+
+	return responseErr.StatusCode, true
+}
+
+// networkError returns whether the given error occurred while talking to vault, before any response was received
+func networkError(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+
+	_, ok := err.(net.Error)
+	return ok
+}
+
+// unsent returns whether the given error tells that the request was not sent to vault, as the connection could not be
+// established
+func unsent(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	opErr, ok := err.(*net.OpError)
+	return ok && opErr.Op == "dial"
+}
@@ -0,0 +1,181 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+var (
+	errUnavailable = &api.ResponseError{HTTPMethod: "GET", URL: "http://vault/v1/secret/foo", StatusCode: 503, Errors: []string{"Vault is sealed"}}
+	errForbidden   = &api.ResponseError{HTTPMethod: "GET", URL: "http://vault/v1/secret/foo", StatusCode: 403, Errors: []string{"permission denied"}}
+	errNetwork     = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connect: connection refused")}
+	errReset       = &url.Error{Op: "Post", URL: "http://vault/v1/pki/issue/app", Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")}}
+	errDecode      = errors.New("Code: 503. invalid character '<' looking for beginning of value")
+)
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		code int
+		ok   bool
+	}{
+		{errUnavailable, 503, true},
+		{errForbidden, 403, true},
+		{errNetwork, 0, false},
+		{errDecode, 0, false},
+		{nil, 0, false},
+	}
+
+	for _, test := range tests {
+		code, ok := StatusCode(test.err)
+		if code != test.code || ok != test.ok {
+			t.Errorf("Expected StatusCode(%v) to return %d, %v, got %d, %v", test.err, test.code, test.ok, code, ok)
+		}
+	}
+}
+
+func TestPolicy_Retryable(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	policy := NewPolicy(logger, 3, 0, 0, 0, []int{500, 503})
+
+	if !policy.Retryable(errUnavailable) {
+		t.Error("Expected a 503 error to be retryable")
+	}
+	if policy.Retryable(errForbidden) {
+		t.Error("Expected a 403 error not to be retryable")
+	}
+	if !policy.Retryable(errNetwork) {
+		t.Error("Expected a network error to be retryable")
+	}
+	if !policy.Retryable(io.ErrUnexpectedEOF) {
+		t.Error("Expected an interrupted response to be retryable")
+	}
+	if policy.Retryable(errDecode) {
+		t.Error("Expected an error without status code which is no network error not to be retryable")
+	}
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	policy := NewPolicy(logger, 10, time.Second, 5*time.Second, 0, nil)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if actual := policy.Delay(i + 1); actual != delay {
+			t.Errorf("Expected delay of attempt %d to be %v, got %v", i+1, delay, actual)
+		}
+	}
+
+	policy = NewPolicy(logger, 10, time.Second, 5*time.Second, 0.5, nil)
+	for i := 0; i < 100; i++ {
+		if delay := policy.Delay(1); delay < 500*time.Millisecond || delay > time.Second {
+			t.Fatalf("Expected jittered delay to be between 500ms and 1s, got %v", delay)
+		}
+	}
+}
+
+func TestPolicy_Do(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	policy := NewPolicy(logger, 3, 0, 0, 0, []int{503})
+
+	tests := []struct {
+		name   string
+		errors []error
+		calls  int
+		err    error
+	}{
+		{"success", nil, 1, nil},
+		{"recovers", []error{errUnavailable, errNetwork}, 3, nil},
+		{"exhausted", []error{errUnavailable, errUnavailable, errUnavailable, errUnavailable}, 3, errUnavailable},
+		{"not retryable", []error{errForbidden}, 1, errForbidden},
+		{"no network error", []error{errDecode}, 1, errDecode},
+	}
+
+	for _, test := range tests {
+		calls := 0
+		err := policy.Do(context.Background(), "test", func() error {
+			calls++
+			if calls <= len(test.errors) {
+				return test.errors[calls-1]
+			}
+			return nil
+		})
+
+		if err != test.err {
+			t.Errorf("%s: Expected error %v, got %v", test.name, test.err, err)
+		}
+		if calls != test.calls {
+			t.Errorf("%s: Expected %d calls, got %d", test.name, test.calls, calls)
+		}
+	}
+}
+
+func TestPolicy_DoUnsent(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	policy := NewPolicy(logger, 3, 0, 0, 0, []int{503})
+
+	tests := []struct {
+		name   string
+		errors []error
+		calls  int
+		err    error
+	}{
+		{"not connected", []error{errNetwork, &url.Error{Op: "Post", Err: errNetwork}}, 3, nil},
+		{"connection reset", []error{errReset}, 1, errReset},
+		{"retryable status code", []error{errUnavailable}, 1, errUnavailable},
+	}
+
+	for _, test := range tests {
+		calls := 0
+		err := policy.DoUnsent(context.Background(), "test", func() error {
+			calls++
+			if calls <= len(test.errors) {
+				return test.errors[calls-1]
+			}
+			return nil
+		})
+
+		if err != test.err {
+			t.Errorf("%s: Expected error %v, got %v", test.name, test.err, err)
+		}
+		if calls != test.calls {
+			t.Errorf("%s: Expected %d calls, got %d", test.name, test.calls, calls)
+		}
+	}
+}
+
+func TestPolicy_DoCanceled(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	policy := NewPolicy(logger, 3, time.Hour, time.Hour, 0, []int{503})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- policy.Do(ctx, "test", func() error {
+			calls++
+			return errUnavailable
+		})
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != errUnavailable {
+			t.Errorf("Expected error %v, got %v", errUnavailable, err)
+		}
+		if calls != 1 {
+			t.Errorf("Expected %d calls, got %d", 1, calls)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Do to return once the context is done")
+	}
+}
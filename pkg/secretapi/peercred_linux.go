@@ -0,0 +1,39 @@
+package secretapi
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// peerCredentialsSupported returns an error if the credentials of peers can not be checked on this platform
+func peerCredentialsSupported() error {
+	return nil
+}
+
+// peerCredentials returns the uid and gid of the process connected to the given unix socket connection
+func peerCredentials(conn net.Conn) (int, int, error) {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return 0, 0, fmt.Errorf("unexpected connection type %T", conn)
+	}
+
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to access connection: %v", err)
+	}
+
+	var cred *syscall.Ucred
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to access connection: %v", err)
+	}
+	if credErr != nil {
+		return 0, 0, fmt.Errorf("failed to read peer credentials: %v", credErr)
+	}
+
+	return int(cred.Uid), int(cred.Gid), nil
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package secretapi
+
+import (
+	"errors"
+	"net"
+)
+
+var errPeerCredentials = errors.New("checking peer credentials is only supported on linux")
+
+// peerCredentialsSupported returns an error if the credentials of peers can not be checked on this platform
+func peerCredentialsSupported() error {
+	return errPeerCredentials
+}
+
+// peerCredentials returns the uid and gid of the process connected to the given unix socket connection
+func peerCredentials(conn net.Conn) (int, int, error) {
+	return 0, 0, errPeerCredentials
+}
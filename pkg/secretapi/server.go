@@ -0,0 +1,299 @@
+package secretapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const (
+	// shutdownTimeout is how long to wait for running requests when shutting down
+	shutdownTimeout = 5 * time.Second
+	// defaultWait is how long watches block if no wait time is given
+	defaultWait = time.Minute
+	// maxWait limits how long watches block
+	maxWait = 5 * time.Minute
+	// indexHeader contains the index of the store, to be passed as index parameter to watch for the next change
+	indexHeader = "X-Kube-Vault-Index"
+)
+
+// Server serves the secrets of a store on a unix socket, so applications are able to fetch the current secrets and
+// watch them for changes:
+//
+//	GET /v1/secrets                  lists the names and indexes of all secrets
+//	GET /v1/secrets/<name>           returns the data of the secret with the given SECRET_ name
+//	GET /v1/secrets/<name>?index=12  blocks until the secret changed after index 12, at most 1m or ?wait=<duration>
+//
+// Access is restricted by the permissions of the socket file and, if allowed uids or gids are given, by the
+// credentials of the connected peer.
+type Server struct {
+	logger      *logrus.Entry
+	path        string
+	mode        os.FileMode
+	uid         int
+	gid         int
+	allowedUIDs map[int]bool
+	allowedGIDs map[int]bool
+	store       *Store
+	mux         *http.ServeMux
+}
+
+// NewServer returns a new Server instance listening on the socket at the given path. The socket file gets the given
+// mode and is owned by uid and gid, which are left unchanged if -1. Peers are only allowed to connect if their uid or
+// gid is contained in allowedUIDs or allowedGIDs, unless both are empty.
+func NewServer(logger *logrus.Entry, path string, mode os.FileMode, uid, gid int, allowedUIDs, allowedGIDs []int, store *Store) *Server {
+	s := &Server{
+		logger:      logger,
+		path:        path,
+		mode:        mode,
+		uid:         uid,
+		gid:         gid,
+		allowedUIDs: map[int]bool{},
+		allowedGIDs: map[int]bool{},
+		store:       store,
+		mux:         http.NewServeMux(),
+	}
+
+	for _, id := range allowedUIDs {
+		s.allowedUIDs[id] = true
+	}
+	for _, id := range allowedGIDs {
+		s.allowedGIDs[id] = true
+	}
+
+	s.mux.HandleFunc("/v1/secrets", s.handleList)
+	s.mux.HandleFunc("/v1/secrets/", s.handleSecret)
+
+	return s
+}
+
+// Handler returns the handler serving all endpoints
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start listens on the socket and serves the endpoints in the background until the context is done. The socket file
+// is removed on shutdown.
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{Handler: s.mux}
+
+	go func() {
+		s.logger.Infof("Serving secrets on socket %s", s.path)
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Fatalf("failed to serve secrets: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		// watches block until they time out, so they are not waited for
+		srv.SetKeepAlivesEnabled(false)
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.logger.Debugf("Closing the secrets socket with requests running: %v", err)
+			_ = srv.Close()
+		}
+	}()
+
+	return nil
+}
+
+// listen creates the socket file with the configured permissions, replacing the one of a previous run
+func (s *Server) listen() (net.Listener, error) {
+	if len(s.allowedUIDs) > 0 || len(s.allowedGIDs) > 0 {
+		if err := peerCredentialsSupported(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove existing socket %s: %v", s.path, err)
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on socket %s: %v", s.path, err)
+	}
+
+	if err := os.Chmod(s.path, s.mode); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set mode of socket %s: %v", s.path, err)
+	}
+
+	if s.uid != -1 || s.gid != -1 {
+		if err := os.Chown(s.path, s.uid, s.gid); err != nil {
+			_ = listener.Close()
+			return nil, fmt.Errorf("failed to set owner of socket %s: %v", s.path, err)
+		}
+	}
+
+	if len(s.allowedUIDs) == 0 && len(s.allowedGIDs) == 0 {
+		return listener, nil
+	}
+
+	return &peerListener{Listener: listener, server: s}, nil
+}
+
+// allowed returns whether a peer of the given credentials is allowed to connect
+func (s *Server) allowed(uid, gid int) bool {
+	return s.allowedUIDs[uid] || s.allowedGIDs[gid]
+}
+
+// handleList responds with the names and indexes of all secrets
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	index, wait, err := watchParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if index != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		s.store.Wait(ctx, *index)
+		cancel()
+	}
+
+	w.Header().Set(indexHeader, strconv.FormatUint(s.store.Index(), 10))
+	s.respond(w, http.StatusOK, s.store.List())
+}
+
+// handleSecret responds with the data of a single secret, waiting for it to change if an index is given
+func (s *Server) handleSecret(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/v1/secrets/")
+	if name == "" || strings.Contains(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	index, wait, err := watchParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	secret, ok := s.get(name)
+	if index != nil && (!ok || secret.Index <= *index) {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		current := s.store.Index()
+		for ctx.Err() == nil {
+			current = s.store.Wait(ctx, current)
+			if secret, ok = s.get(name); ok && secret.Index > *index {
+				break
+			}
+		}
+		cancel()
+	}
+
+	w.Header().Set(indexHeader, strconv.FormatUint(s.store.Index(), 10))
+	if !ok {
+		http.Error(w, fmt.Sprintf("secret %q not found", name), http.StatusNotFound)
+		return
+	}
+
+	s.respond(w, http.StatusOK, secret)
+}
+
+// get returns the secret of the given name, falling back to the upper case name as used by SECRET_ env vars
+func (s *Server) get(name string) (*Secret, bool) {
+	if secret, ok := s.store.Get(name); ok {
+		return secret, true
+	}
+
+	return s.store.Get(strings.ToUpper(name))
+}
+
+func (s *Server) respond(w http.ResponseWriter, status int, v interface{}) {
+	content, err := json.Marshal(v)
+	if err != nil {
+		s.logger.Errorf("failed to encode response: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(content)
+}
+
+// watchParams parses the index and wait query parameters of a watch, index is nil if the request is no watch
+func watchParams(r *http.Request) (*uint64, time.Duration, error) {
+	query := r.URL.Query()
+	if query.Get("index") == "" {
+		return nil, 0, nil
+	}
+
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid index %q: %v", query.Get("index"), err)
+	}
+
+	wait := defaultWait
+	if query.Get("wait") != "" {
+		wait, err = time.ParseDuration(query.Get("wait"))
+		if err != nil || wait <= 0 {
+			return nil, 0, fmt.Errorf("invalid wait %q, expected a positive duration like 30s", query.Get("wait"))
+		}
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	return &index, wait, nil
+}
+
+// peerListener accepts only connections of peers allowed by the server
+type peerListener struct {
+	net.Listener
+	server *Server
+}
+
+// Accept returns the next connection of an allowed peer, closing the connections of all others
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, gid, err := peerCredentials(conn)
+		if err != nil {
+			l.server.logger.Warnf("Rejecting connection to the secrets socket: %v", err)
+			_ = conn.Close()
+			continue
+		}
+
+		if !l.server.allowed(uid, gid) {
+			l.server.logger.Warnf("Rejecting connection to the secrets socket of uid %d and gid %d", uid, gid)
+			_ = conn.Close()
+			continue
+		}
+
+		return conn, nil
+	}
+}
@@ -0,0 +1,175 @@
+package secretapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func get(t *testing.T, handler http.Handler, path string) (*httptest.ResponseRecorder, *Secret) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if recorder.Code != http.StatusOK {
+		return recorder, nil
+	}
+
+	secret := &Secret{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), secret); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	return recorder, secret
+}
+
+func TestServer_Secret(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	store := NewStore()
+	store.Update(map[string]interface{}{"DB": map[string]interface{}{"password": "test1234"}})
+	handler := NewServer(logger, "", 0600, -1, -1, nil, nil, store).Handler()
+
+	for _, path := range []string{"/v1/secrets/DB", "/v1/secrets/db"} {
+		recorder, secret := get(t, handler, path)
+		if secret == nil {
+			t.Fatalf("Expected %s to succeed, got status code %d", path, recorder.Code)
+		}
+		if secret.Name != "DB" || secret.Index != 1 || secret.Data.(map[string]interface{})["password"] != "test1234" {
+			t.Errorf("Got unexpected secret from %s: %+v", path, secret)
+		}
+		if recorder.Header().Get(indexHeader) != "1" {
+			t.Errorf("Expected index header 1, got %q", recorder.Header().Get(indexHeader))
+		}
+	}
+
+	for path, code := range map[string]int{
+		"/v1/secrets/MISSING":           http.StatusNotFound,
+		"/v1/secrets/DB?index=abc":      http.StatusBadRequest,
+		"/v1/secrets/DB?index=1&wait=x": http.StatusBadRequest,
+	} {
+		if recorder, _ := get(t, handler, path); recorder.Code != code {
+			t.Errorf("Expected status code %d for %s, got %d", code, path, recorder.Code)
+		}
+	}
+}
+
+func TestServer_Watch(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	store := NewStore()
+	store.Update(map[string]interface{}{"DB": "test1234", "API_KEY": "abcd"})
+	handler := NewServer(logger, "", 0600, -1, -1, nil, nil, store).Handler()
+
+	// changes of other secrets do not end the watch
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		store.Update(map[string]interface{}{"DB": "test1234", "API_KEY": "efgh"})
+		time.Sleep(20 * time.Millisecond)
+		store.Update(map[string]interface{}{"DB": "test5678", "API_KEY": "efgh"})
+	}()
+
+	start := time.Now()
+	_, secret := get(t, handler, "/v1/secrets/DB?index=1&wait=5s")
+	if secret == nil || secret.Data != "test5678" || secret.Index != 3 {
+		t.Fatalf("Expected the changed secret at index 3, got %+v", secret)
+	}
+	if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("Expected the watch to wait for the change of DB")
+	}
+
+	recorder, secret := get(t, handler, "/v1/secrets/DB?index=3&wait=10ms")
+	if secret == nil || secret.Index != 3 {
+		t.Errorf("Expected the unchanged secret after the wait time, got %d: %+v", recorder.Code, secret)
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/v1/secrets?index=3&wait=10ms", nil))
+	var list []*Secret
+	if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	if len(list) != 2 || list[0].Name != "API_KEY" || list[1].Name != "DB" {
+		t.Errorf("Got unexpected list: %+v", list)
+	}
+}
+
+func TestServer_Socket(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "secretapi")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	_, logger := internalTesting.NewLogger()
+	store := NewStore()
+	store.Update(map[string]interface{}{"DB": "test1234"})
+
+	tests := []struct {
+		name        string
+		allowedUIDs []int
+		allowed     bool
+	}{
+		{name: "unrestricted", allowed: true},
+		{name: "allowed", allowedUIDs: []int{os.Getuid()}, allowed: true},
+		{name: "rejected", allowedUIDs: []int{os.Getuid() + 1}, allowed: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name+".sock")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			if err := NewServer(logger, path, 0660, -1, -1, test.allowedUIDs, nil, store).Start(ctx); err != nil {
+				t.Fatalf("Got unexpected error from Start(): %v", err)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatalf("Expected socket to exist: %v", err)
+			}
+			if info.Mode().Perm() != 0660 {
+				t.Errorf("Expected socket mode 0660, got %v", info.Mode().Perm())
+			}
+
+			client := &http.Client{
+				Timeout: time.Second,
+				Transport: &http.Transport{
+					Dial: func(network, addr string) (net.Conn, error) {
+						return net.Dial("unix", path)
+					},
+				},
+			}
+
+			resp, err := client.Get("http://unix/v1/secrets/DB")
+			if !test.allowed {
+				if err == nil {
+					_ = resp.Body.Close()
+					t.Error("Expected the connection to be rejected")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Got unexpected error: %v", err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Expected status code 200, got %d", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package secretapi
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Secret is the current data of a secret along with the index it changed at the last time
+type Secret struct {
+	Name  string      `json:"name"`
+	Index uint64      `json:"index"`
+	Data  interface{} `json:"data,omitempty"`
+}
+
+// Store holds the secrets rendered by the env processor. Each update changing any secret increments the index of the
+// store, which allows clients to wait for changes.
+type Store struct {
+	mu      sync.RWMutex
+	index   uint64
+	secrets map[string]*Secret
+	changed chan struct{}
+}
+
+// NewStore returns a new, empty Store instance
+func NewStore() *Store {
+	return &Store{
+		secrets: map[string]*Secret{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Update replaces the secrets of the store with the given ones, keyed by their SECRET_ names. Waiting clients are
+// notified if any secret changed.
+func (s *Store) Update(secrets map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := s.index + 1
+	changed := false
+	for name, data := range secrets {
+		existing, ok := s.secrets[name]
+		if ok && reflect.DeepEqual(existing.Data, data) {
+			continue
+		}
+
+		s.secrets[name] = &Secret{Name: name, Index: index, Data: data}
+		changed = true
+	}
+
+	for name := range s.secrets {
+		if _, ok := secrets[name]; !ok {
+			delete(s.secrets, name)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return
+	}
+
+	s.index = index
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Index returns the index of the last change
+func (s *Store) Index() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.index
+}
+
+// Get returns the secret of the given name
+func (s *Store) Get(name string) (*Secret, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	secret, ok := s.secrets[name]
+	return secret, ok
+}
+
+// List returns the names and indexes of all secrets, sorted by name
+func (s *Store) List() []*Secret {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	secrets := make([]*Secret, 0, len(s.secrets))
+	for _, secret := range s.secrets {
+		secrets = append(secrets, &Secret{Name: secret.Name, Index: secret.Index})
+	}
+
+	sort.Slice(secrets, func(a, b int) bool { return secrets[a].Name < secrets[b].Name })
+
+	return secrets
+}
+
+// Wait blocks until the index of the store is greater than the given one or the context is done, returning the
+// current index
+func (s *Store) Wait(ctx context.Context, index uint64) uint64 {
+	for {
+		s.mu.RLock()
+		current, changed := s.index, s.changed
+		s.mu.RUnlock()
+
+		if current > index {
+			return current
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return current
+		}
+	}
+}
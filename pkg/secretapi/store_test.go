@@ -0,0 +1,75 @@
+package secretapi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStore_Update(t *testing.T) {
+	s := NewStore()
+	s.Update(map[string]interface{}{"DB": map[string]interface{}{"password": "test1234"}, "API_KEY": "abcd"})
+	if s.Index() != 1 {
+		t.Fatalf("Expected index 1, got %d", s.Index())
+	}
+
+	// unchanged secrets keep their index
+	s.Update(map[string]interface{}{"DB": map[string]interface{}{"password": "test5678"}, "API_KEY": "abcd"})
+	if s.Index() != 2 {
+		t.Fatalf("Expected index 2, got %d", s.Index())
+	}
+
+	db, _ := s.Get("DB")
+	apiKey, _ := s.Get("API_KEY")
+	if db.Index != 2 || apiKey.Index != 1 {
+		t.Errorf("Expected indexes 2 and 1, got %d and %d", db.Index, apiKey.Index)
+	}
+
+	s.Update(map[string]interface{}{"DB": map[string]interface{}{"password": "test5678"}, "API_KEY": "abcd"})
+	if s.Index() != 2 {
+		t.Errorf("Expected index to stay 2 without changes, got %d", s.Index())
+	}
+
+	s.Update(map[string]interface{}{"DB": map[string]interface{}{"password": "test5678"}})
+	if _, ok := s.Get("API_KEY"); ok || s.Index() != 3 {
+		t.Errorf("Expected API_KEY to be removed at index 3, got index %d", s.Index())
+	}
+
+	list := s.List()
+	if len(list) != 1 || list[0].Name != "DB" || list[0].Data != nil {
+		t.Errorf("Expected list of DB without data, got %v", list)
+	}
+}
+
+func TestStore_Wait(t *testing.T) {
+	s := NewStore()
+	s.Update(map[string]interface{}{"DB": "test1234"})
+
+	done := make(chan uint64)
+	go func() {
+		done <- s.Wait(context.Background(), 1)
+	}()
+
+	select {
+	case index := <-done:
+		t.Fatalf("Expected Wait() to block, returned %d", index)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.Update(map[string]interface{}{"DB": "test5678"})
+
+	select {
+	case index := <-done:
+		if index != 2 {
+			t.Errorf("Expected index 2, got %d", index)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected Wait() to return after the update")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if index := s.Wait(ctx, 2); index != 2 {
+		t.Errorf("Expected Wait() to return the current index 2 on timeout, got %d", index)
+	}
+}
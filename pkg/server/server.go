@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// shutdownTimeout is how long to wait for running requests when shutting down
+const shutdownTimeout = 5 * time.Second
+
+type leaseManager interface {
+	Status() lease.Status
+	Ready() bool
+}
+
+// Server serves the health, readiness, status and metrics endpoints of the renew sidecar
+type Server struct {
+	logger  *logrus.Entry
+	addr    string
+	manager leaseManager
+	mux     *http.ServeMux
+}
+
+// NewServer returns a new Server instance listening on the given address
+func NewServer(logger *logrus.Entry, addr string, manager leaseManager) *Server {
+	s := &Server{
+		logger:  logger,
+		addr:    addr,
+		manager: manager,
+		mux:     http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/readyz", s.handleReady)
+	s.mux.HandleFunc("/status", s.handleStatus)
+	s.mux.Handle("/metrics", promhttp.Handler())
+
+	return s
+}
+
+// Handler returns the handler serving all endpoints
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// Start serves the endpoints in the background until the context is done
+func (s *Server) Start(ctx context.Context) {
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: s.mux,
+	}
+
+	go func() {
+		s.logger.Infof("Serving status endpoints on %s", s.addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			s.logger.Fatalf("failed to serve status endpoints: %v", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			s.logger.Errorf("failed to shut down status server: %v", err)
+		}
+	}()
+}
+
+// handleHealth reports the sidecar to be alive as long as it is serving requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleReady reports the sidecar to be ready if the auth token is valid and all leases were renewed within their TTL
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.manager.Ready() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+// handleStatus responds with the renewal state of the auth token and all leases as json
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	body, err := json.MarshalIndent(s.manager.Status(), "", "  ")
+	if err != nil {
+		s.logger.Errorf("failed to encode status: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	"github.com/libri-gmbh/kube-vault/pkg/lease"
+)
+
+type fakeManager struct {
+	status lease.Status
+}
+
+func (m *fakeManager) Status() lease.Status {
+	return m.status
+}
+
+func (m *fakeManager) Ready() bool {
+	return m.status.Ready
+}
+
+func serve(s *Server, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	s.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	return recorder
+}
+
+func TestServer_Health(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	s := NewServer(logger, "", &fakeManager{})
+
+	if resp := serve(s, "/healthz"); resp.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, resp.Code)
+	}
+}
+
+func TestServer_Ready(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	manager := &fakeManager{}
+	s := NewServer(logger, "", manager)
+
+	if resp := serve(s, "/readyz"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d while not ready, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+
+	manager.status.Ready = true
+	if resp := serve(s, "/readyz"); resp.Code != http.StatusOK {
+		t.Errorf("Expected status code %d while ready, got %d", http.StatusOK, resp.Code)
+	}
+}
+
+func TestServer_Status(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	manager := &fakeManager{status: lease.Status{
+		Ready: true,
+		Token: lease.TokenStatus{TTL: 1800},
+		Leases: []lease.LeaseStatus{
+			{LeaseID: "database/creds/app/abc", Names: []string{"DB"}, TTL: 300},
+		},
+	}}
+	s := NewServer(logger, "", manager)
+
+	resp := serve(s, "/status")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.Code)
+	}
+	if contentType := resp.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected content type application/json, got %q", contentType)
+	}
+
+	var status lease.Status
+	if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+
+	if len(status.Leases) != 1 || status.Leases[0].LeaseID != "database/creds/app/abc" || status.Leases[0].TTL != 300 {
+		t.Errorf("Expected the lease to be listed, got %+v", status.Leases)
+	}
+	if status.Token.TTL != 1800 {
+		t.Errorf("Expected token ttl %d, got %d", 1800, status.Token.TTL)
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	s := NewServer(logger, "", &fakeManager{})
+
+	resp := serve(s, "/metrics")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, resp.Code)
+	}
+	if !strings.Contains(resp.Body.String(), "go_goroutines") {
+		t.Errorf("Expected the default metrics to be served, got %q", resp.Body.String())
+	}
+}
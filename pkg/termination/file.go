@@ -0,0 +1,51 @@
+package termination
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// File detects the pod termination by a trigger file, created by the preStop hook of the container
+type File struct {
+	path string
+}
+
+// NewFile returns a new File instance
+func NewFile(path string) *File {
+	return &File{
+		path: path,
+	}
+}
+
+func (f *File) String() string {
+	return fmt.Sprintf("trigger file %s", f.path)
+}
+
+// Terminating returns whether the trigger file exists
+func (f *File) Terminating() (bool, error) {
+	_, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Trigger creates the trigger file
+func (f *File) Trigger() error {
+	return ioutil.WriteFile(f.path, nil, 0644)
+}
+
+// Clear removes a trigger file left by a previous run of the container, e.g. if the preStop hook ran as the container
+// was restarted after failed liveness probes
+func (f *File) Clear() error {
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package termination
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	// podTimeout is the time to wait for the api server to return the pod
+	podTimeout = 5 * time.Second
+)
+
+// Pod detects the pod termination by its deletion timestamp, which the kubernetes api sets once the pod is deleted.
+// The service account needs to be allowed to get the pod.
+type Pod struct {
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+// NewPod returns a new Pod instance, asking the api server for the pod with the given namespace and name
+func NewPod(client kubernetes.Interface, namespace, name string) *Pod {
+	return &Pod{
+		client:    client,
+		namespace: namespace,
+		name:      name,
+	}
+}
+
+// NewInClusterPod returns a new Pod instance using the service account of the pod to talk to the api server. The
+// namespace of the service account is used if namespace is empty.
+func NewInClusterPod(namespace, name string) (*Pod, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure the kubernetes client: %v", err)
+	}
+	config.Timeout = podTimeout
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the kubernetes client: %v", err)
+	}
+
+	if namespace == "" {
+		// nolint: gosec
+		content, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read namespace of the service account: %v", err)
+		}
+		namespace = strings.TrimSpace(string(content))
+	}
+
+	return NewPod(client, namespace, name), nil
+}
+
+func (p *Pod) String() string {
+	return fmt.Sprintf("pod %s/%s", p.namespace, p.name)
+}
+
+// Terminating returns whether the deletion timestamp of the pod is set
+func (p *Pod) Terminating() (bool, error) {
+	pod, err := p.client.CoreV1().Pods(p.namespace).Get(p.name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return pod.DeletionTimestamp != nil, nil
+}
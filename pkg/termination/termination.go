@@ -0,0 +1,32 @@
+package termination
+
+import (
+	"github.com/Sirupsen/logrus"
+)
+
+// Detector tells whether the pod kube-vault runs in is terminating, as opposed to a restart of the container only
+type Detector interface {
+	// String returns a short human readable description of the detector, used for logging
+	String() string
+	// Terminating returns whether the pod is terminating
+	Terminating() (bool, error)
+}
+
+// Terminating returns whether any of the given detectors reports the pod to be terminating. Failing detectors are
+// logged and treated as not terminating, keeping the credentials usable.
+func Terminating(logger *logrus.Entry, detectors ...Detector) bool {
+	for _, detector := range detectors {
+		terminating, err := detector.Terminating()
+		if err != nil {
+			logger.Errorf("failed to detect pod termination using %s: %v", detector, err)
+			continue
+		}
+
+		if terminating {
+			logger.Infof("Pod termination detected using %s", detector)
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,96 @@
+package termination
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeFake "k8s.io/client-go/kubernetes/fake"
+)
+
+type staticDetector struct {
+	terminating bool
+	err         error
+}
+
+func (d *staticDetector) String() string {
+	return "static detector"
+}
+
+func (d *staticDetector) Terminating() (bool, error) {
+	return d.terminating, d.err
+}
+
+func TestTerminating(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+
+	failing := &staticDetector{err: errors.New("failed")}
+	if Terminating(logger, failing, &staticDetector{}) {
+		t.Error("Expected failing detectors not to report termination")
+	}
+	if !Terminating(logger, failing, &staticDetector{terminating: true}) {
+		t.Error("Expected termination to be reported by any detector")
+	}
+}
+
+func TestFile(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	dir, clean, err := internalTesting.CreateTempDir(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	file := NewFile(filepath.Join(dir, "terminating"))
+	assertTerminating(t, file, false)
+
+	if err := file.Trigger(); err != nil {
+		t.Fatal(err)
+	}
+	assertTerminating(t, file, true)
+
+	if err := file.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	assertTerminating(t, file, false)
+
+	if err := file.Clear(); err != nil {
+		t.Errorf("Expected clearing a missing trigger file to succeed, got %v", err)
+	}
+}
+
+func TestPod(t *testing.T) {
+	client := kubeFake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app"},
+	})
+
+	pod := NewPod(client, "default", "app")
+	assertTerminating(t, pod, false)
+
+	deleted := metav1.NewTime(time.Date(2018, 11, 5, 10, 0, 0, 0, time.UTC))
+	if _, err := client.CoreV1().Pods("default").Update(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "app", DeletionTimestamp: &deleted},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	assertTerminating(t, pod, true)
+
+	if _, err := NewPod(client, "default", "missing").Terminating(); err == nil {
+		t.Error("Expected an error for a missing pod, got none")
+	}
+}
+
+func assertTerminating(t *testing.T, detector Detector, expected bool) {
+	terminating, err := detector.Terminating()
+	if err != nil {
+		t.Fatalf("Got unexpected error from %s: %v", detector, err)
+	}
+
+	if terminating != expected {
+		t.Errorf("Expected %s to report terminating: %v, got %v", detector, expected, terminating)
+	}
+}
@@ -0,0 +1,63 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type appRoleAuth struct {
+	RoleID   string `json:"role_id"`
+	SecretID string `json:"secret_id"`
+}
+
+// AppRoleAuth logs in at the vault approle auth method, used by workloads running outside of kubernetes
+type AppRoleAuth struct {
+	path         string
+	roleID       string
+	secretID     string
+	secretIDFile string
+}
+
+// NewAppRoleAuth returns a new AppRoleAuth instance. If secretIDFile is not empty the secret id is read from that
+// file on every login, otherwise the given secretID is used.
+func NewAppRoleAuth(path, roleID, secretID, secretIDFile string) *AppRoleAuth {
+	return &AppRoleAuth{
+		path:         path,
+		roleID:       roleID,
+		secretID:     secretID,
+		secretIDFile: secretIDFile,
+	}
+}
+
+func (a *AppRoleAuth) String() string {
+	return fmt.Sprintf("approle %s at %s", a.roleID, a.path)
+}
+
+// LoginPath returns the login endpoint of the approle auth method
+func (a *AppRoleAuth) LoginPath() string {
+	return fmt.Sprintf("/v1/auth/%s/login", a.path)
+}
+
+// LoginData returns the role id and the secret id, which is read from the secret id file if configured
+func (a *AppRoleAuth) LoginData() (interface{}, error) {
+	if a.roleID == "" {
+		return nil, errors.New("no approle role id given")
+	}
+
+	secretID := a.secretID
+	if a.secretIDFile != "" {
+		// nolint: gosec
+		b, err := ioutil.ReadFile(a.secretIDFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read approle secret id: %v", err)
+		}
+		secretID = strings.TrimSpace(string(b))
+	}
+	if secretID == "" {
+		return nil, errors.New("no approle secret id given")
+	}
+
+	return &appRoleAuth{RoleID: a.roleID, SecretID: secretID}, nil
+}
@@ -0,0 +1,75 @@
+package vault
+
+import (
+	"io/ioutil"
+	"testing"
+
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+)
+
+func TestAppRoleAuth_LoginData(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	secretIDFile, clean, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	if err := ioutil.WriteFile(secretIDFile, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	emptyFile, cleanEmpty, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanEmpty()
+
+	tests := []struct {
+		name         string
+		roleID       string
+		secretID     string
+		secretIDFile string
+		expected     string
+		fails        bool
+	}{
+		{name: "secret id", roleID: "role", secretID: "env-secret", expected: "env-secret"},
+		{name: "secret id file", roleID: "role", secretIDFile: secretIDFile, expected: "file-secret"},
+		{name: "secret id file takes precedence", roleID: "role", secretID: "env-secret", secretIDFile: secretIDFile, expected: "file-secret"},
+		{name: "missing role id", secretID: "env-secret", fails: true},
+		{name: "missing secret id", roleID: "role", fails: true},
+		{name: "empty secret id file", roleID: "role", secretID: "env-secret", secretIDFile: emptyFile, fails: true},
+		{name: "missing secret id file", roleID: "role", secretIDFile: secretIDFile + ".missing", fails: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := NewAppRoleAuth("approle", test.roleID, test.secretID, test.secretIDFile).LoginData()
+			if test.fails {
+				if err == nil {
+					t.Errorf("Expected an error, got login data %+v", data)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got unexpected error from LoginData(): %v", err)
+			}
+
+			login, ok := data.(*appRoleAuth)
+			if !ok {
+				t.Fatalf("Expected approle login data, got %T", data)
+			}
+			if login.RoleID != test.roleID {
+				t.Errorf("Expected role id %q, got %q", test.roleID, login.RoleID)
+			}
+			if login.SecretID != test.expected {
+				t.Errorf("Expected secret id %q, got %q", test.expected, login.SecretID)
+			}
+		})
+	}
+}
+
+func TestAppRoleAuth_LoginPath(t *testing.T) {
+	if path := NewAppRoleAuth("ci/approle", "role", "secret", "").LoginPath(); path != "/v1/auth/ci/approle/login" {
+		t.Errorf("Expected login path %q, got %q", "/v1/auth/ci/approle/login", path)
+	}
+}
@@ -1,33 +1,31 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/hashicorp/vault/api"
+	"github.com/libri-gmbh/kube-vault/pkg/metrics"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
 )
 
-type kubeAuth struct {
-	JWT  string `json:"jwt"`
-	Role string `json:"role"`
-}
-
 type vaultClient interface {
 	NewRequest(method, requestPath string) *api.Request
 	RawRequest(request *api.Request) (*api.Response, error)
 	SetToken(v string)
 }
 
-// Authenticator handles vault kubernetes authentication
+// Authenticator handles vault authentication using one of the supported auth methods
 type Authenticator struct {
 	logger *logrus.Entry
 	client vaultClient
+	policy *retry.Policy
 	token  *api.Secret
 }
 
@@ -36,17 +34,20 @@ var (
 	errTokenIsNil             = errors.New("given token is nil or empty")
 )
 
-// NewAuthenticator returns a new Authenticator instance
-func NewAuthenticator(logger *logrus.Entry, client vaultClient) *Authenticator {
+// NewAuthenticator returns a new Authenticator instance, failed logins are retried using the given policy
+func NewAuthenticator(logger *logrus.Entry, client vaultClient, policy *retry.Policy) *Authenticator {
 	return &Authenticator{
 		logger: logger,
 		client: client,
+		policy: policy,
 	}
 }
 
-// Authenticate hands over the k8s SA token to vault, receiving the vault authentication token
-func (f *Authenticator) Authenticate(forceLogin bool, kubeAuthPath, kubeAuthRole, kubeTokenFilePath, vaultTokenFilePath string) (*api.Secret, error) {
-	if !forceLogin {
+// Authenticate logs in at vault using the given auth method, receiving the vault authentication token. The token is
+// handed over using the given token file, if vaultTokenFilePath is empty no token file is used. Failed logins are
+// retried until the context is done.
+func (f *Authenticator) Authenticate(ctx context.Context, forceLogin bool, method AuthMethod, vaultTokenFilePath string) (*api.Secret, error) {
+	if !forceLogin && vaultTokenFilePath != "" {
 		// first try to read the vault token - if this is successful we are already logged in
 		token, err := f.readTokenFile(vaultTokenFilePath)
 		if err != nil && err != errVaultTokenFileNotFound {
@@ -56,15 +57,17 @@ func (f *Authenticator) Authenticate(forceLogin bool, kubeAuthPath, kubeAuthRole
 		}
 	}
 
-	token, err := f.fetchKubeAuthToken(kubeTokenFilePath, kubeAuthPath, kubeAuthRole)
+	token, err := f.login(ctx, method)
 	if err != nil {
 		return nil, err
 	}
 
-	f.logger.Infof("successfully authenticated kube role %s at %s", kubeAuthRole, kubeAuthPath)
+	f.logger.Infof("successfully authenticated %s", method)
 
-	if err := f.writeTokenToFile(token, vaultTokenFilePath); err != nil {
-		return nil, fmt.Errorf("failed to save token to file: %v", err)
+	if vaultTokenFilePath != "" {
+		if err := f.writeTokenToFile(token, vaultTokenFilePath); err != nil {
+			return nil, fmt.Errorf("failed to save token to file: %v", err)
+		}
 	}
 
 	f.token = token
@@ -73,31 +76,38 @@ func (f *Authenticator) Authenticate(forceLogin bool, kubeAuthPath, kubeAuthRole
 	return token, nil
 }
 
-func (f *Authenticator) fetchKubeAuthToken(kubeTokenFilePath, kubeAuthPath, kubeAuthRole string) (*api.Secret, error) {
-	// nolint: gosec
-	k8sTokenBytes, err := ioutil.ReadFile(kubeTokenFilePath)
+// login posts the login data of the given auth method to its login endpoint
+func (f *Authenticator) login(ctx context.Context, method AuthMethod) (*api.Secret, error) {
+	data, err := method.LoginData()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read token: %s", err)
+		return nil, err
 	}
 
-	k8sToken := strings.TrimSpace(string(k8sTokenBytes))
-	f.logger.Debugf("loaded JWT token %v from kube token path %q", k8sToken, kubeTokenFilePath)
+	f.logger.Debugf("logging in with %s at %q", method, method.LoginPath())
 
-	req := f.client.NewRequest(http.MethodPost, fmt.Sprintf("/v1/auth/%s/login", kubeAuthPath))
-	err = req.SetJSONBody(&kubeAuth{JWT: k8sToken, Role: kubeAuthRole})
-	if err != nil {
-		return nil, fmt.Errorf("failed to set json body on auth request: %v", err)
-	}
+	var resp *api.Response
+	err = f.policy.Do(ctx, "log in with "+method.String(), func() error {
+		// the request body is consumed on each attempt, so the request is built again
+		req := f.client.NewRequest(http.MethodPost, method.LoginPath())
+		if err := req.SetJSONBody(data); err != nil {
+			return fmt.Errorf("failed to set json body on auth request: %v", err)
+		}
+
+		metrics.LoginAttempts.Inc()
+		resp, err = f.client.RawRequest(req)
+		if err == nil {
+			err = resp.Error()
+		}
+		if err != nil {
+			metrics.LoginFailures.Inc()
+		}
 
-	resp, err := f.client.RawRequest(req)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.Error() != nil {
-		return nil, resp.Error()
-	}
-
 	token := &api.Secret{}
 	err = json.NewDecoder(resp.Body).Decode(token)
 	if err != nil {
@@ -0,0 +1,58 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type jwtAuth struct {
+	JWT  string `json:"jwt"`
+	Role string `json:"role,omitempty"`
+}
+
+// JWTAuth logs in at the vault jwt auth method using a projected k8s service account token. The token file is read
+// on every login, as the kubelet rotates projected tokens before they expire.
+type JWTAuth struct {
+	path      string
+	role      string
+	tokenFile string
+}
+
+// NewJWTAuth returns a new JWTAuth instance. If role is empty the default role of the auth method is used.
+func NewJWTAuth(path, role, tokenFile string) *JWTAuth {
+	return &JWTAuth{
+		path:      path,
+		role:      role,
+		tokenFile: tokenFile,
+	}
+}
+
+func (a *JWTAuth) String() string {
+	if a.role == "" {
+		return fmt.Sprintf("jwt default role at %s", a.path)
+	}
+
+	return fmt.Sprintf("jwt role %s at %s", a.role, a.path)
+}
+
+// LoginPath returns the login endpoint of the jwt auth method
+func (a *JWTAuth) LoginPath() string {
+	return fmt.Sprintf("/v1/auth/%s/login", a.path)
+}
+
+// LoginData reads the current projected token and returns it along with the role to assume
+func (a *JWTAuth) LoginData() (interface{}, error) {
+	// nolint: gosec
+	b, err := ioutil.ReadFile(a.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read projected token: %v", err)
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return nil, fmt.Errorf("projected token file %q is empty", a.tokenFile)
+	}
+
+	return &jwtAuth{JWT: token, Role: a.role}, nil
+}
@@ -0,0 +1,81 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/api"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	"github.com/libri-gmbh/kube-vault/pkg/retry"
+)
+
+func TestJWTAuth_LoginRotatedToken(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	tokenFile, clean, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	var path string
+	var body map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body = nil
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"vault-token","lease_duration":3600,"renewable":true}}`))
+	}))
+	defer server.Close()
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator := NewAuthenticator(logger, client, retry.NewPolicy(logger, 1, 0, 0, 0, nil))
+	method := NewJWTAuth("jwt", "app", tokenFile)
+
+	// the kubelet rotates the projected token between the logins
+	for _, token := range []string{"first-token", "rotated-token"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := authenticator.Authenticate(context.Background(), true, method, "")
+		if err != nil {
+			t.Fatalf("Got unexpected error from Authenticate(): %v", err)
+		}
+
+		if path != "/v1/auth/jwt/login" {
+			t.Errorf("Expected a login at %q, got %q", "/v1/auth/jwt/login", path)
+		}
+		if body["jwt"] != token || body["role"] != "app" {
+			t.Errorf("Expected login with jwt %q and role %q, got %v", token, "app", body)
+		}
+		if secret.Auth == nil || secret.Auth.ClientToken != "vault-token" {
+			t.Errorf("Expected the vault token to be returned, got %+v", secret.Auth)
+		}
+		if client.Token() != "vault-token" {
+			t.Errorf("Expected the client to use the vault token, got %q", client.Token())
+		}
+	}
+}
+
+func TestJWTAuth_LoginDataEmptyToken(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	tokenFile, clean, err := internalTesting.CreateTempFile(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	if data, err := NewJWTAuth("jwt", "app", tokenFile).LoginData(); err == nil {
+		t.Errorf("Expected an error for an empty token file, got login data %+v", data)
+	}
+}
@@ -0,0 +1,48 @@
+package vault
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+type kubeAuth struct {
+	JWT  string `json:"jwt"`
+	Role string `json:"role"`
+}
+
+// KubernetesAuth logs in at the vault kubernetes auth method using the k8s service account token
+type KubernetesAuth struct {
+	path      string
+	role      string
+	tokenFile string
+}
+
+// NewKubernetesAuth returns a new KubernetesAuth instance
+func NewKubernetesAuth(path, role, tokenFile string) *KubernetesAuth {
+	return &KubernetesAuth{
+		path:      path,
+		role:      role,
+		tokenFile: tokenFile,
+	}
+}
+
+func (a *KubernetesAuth) String() string {
+	return fmt.Sprintf("kube role %s at %s", a.role, a.path)
+}
+
+// LoginPath returns the login endpoint of the kubernetes auth method
+func (a *KubernetesAuth) LoginPath() string {
+	return fmt.Sprintf("/v1/auth/%s/login", a.path)
+}
+
+// LoginData reads the service account token and returns it along with the role to assume
+func (a *KubernetesAuth) LoginData() (interface{}, error) {
+	// nolint: gosec
+	k8sTokenBytes, err := ioutil.ReadFile(a.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token: %s", err)
+	}
+
+	return &kubeAuth{JWT: strings.TrimSpace(string(k8sTokenBytes)), Role: a.role}, nil
+}
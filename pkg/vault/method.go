@@ -0,0 +1,11 @@
+package vault
+
+// AuthMethod describes a vault auth backend the Authenticator is able to log in to
+type AuthMethod interface {
+	// String returns a short human readable description of the method, used for logging
+	String() string
+	// LoginPath returns the vault api path of the login endpoint
+	LoginPath() string
+	// LoginData returns the request body to be sent to the login endpoint
+	LoginData() (interface{}, error)
+}
@@ -0,0 +1,179 @@
+package webhook
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// annotationPrefix prefixes all annotations configuring the injection
+	annotationPrefix = "kube-vault.libri.de/"
+	// secretAnnotationPrefix prefixes the annotations referencing secrets, e.g. "kube-vault.libri.de/secret-aws"
+	secretAnnotationPrefix = annotationPrefix + "secret-"
+
+	initContainerName  = "vault-init"
+	renewContainerName = "vault-renew"
+	volumeName         = "kube-vault-env"
+	volumePath         = "/env"
+)
+
+// Injector adds the init and renew containers of kube-vault to pods referencing secrets by annotations:
+//
+//	kube-vault.libri.de/secret-aws: dev/example/aws/creds/write  # SECRET_AWS, required to inject the containers
+//	kube-vault.libri.de/role: dev-example-write                  # KUBE_AUTH_ROLE
+//	kube-vault.libri.de/auth-path: dev/example/k8s               # KUBE_AUTH_PATH
+//	kube-vault.libri.de/vault-addr: http://vault:8200            # VAULT_ADDR, defaults to the one of the injector
+//	kube-vault.libri.de/containers: app,worker                   # containers to mount the secrets into, all if empty
+//	kube-vault.libri.de/mount-path: /vault                       # where the secrets are mounted, defaults to /env
+//
+// The containers share an in-memory emptyDir, which is mounted read-only into the application containers.
+type Injector struct {
+	image     string
+	vaultAddr string
+}
+
+// NewInjector returns a new Injector instance, injecting containers using the given image and vault address
+func NewInjector(image, vaultAddr string) *Injector {
+	return &Injector{
+		image:     image,
+		vaultAddr: vaultAddr,
+	}
+}
+
+// patchOperation is a json patch operation as defined by RFC 6902
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// inject returns the patch adding the kube-vault containers to the given pod, none if the pod does not reference
+// secrets or contains the containers already
+func (i *Injector) inject(pod *corev1.Pod) ([]patchOperation, error) {
+	annotations := pod.Annotations
+
+	var secrets []corev1.EnvVar
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, secretAnnotationPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(key, secretAnnotationPrefix)
+		if name == "" || strings.TrimSpace(value) == "" {
+			return nil, fmt.Errorf("invalid secret annotation %q, expected %s<name>: <path>", key, secretAnnotationPrefix)
+		}
+
+		secrets = append(secrets, corev1.EnvVar{
+			Name:  "SECRET_" + strings.ToUpper(strings.Replace(name, "-", "_", -1)),
+			Value: strings.TrimSpace(value),
+		})
+	}
+
+	if len(secrets) == 0 || injected(pod) {
+		return nil, nil
+	}
+
+	sort.Slice(secrets, func(a, b int) bool { return secrets[a].Name < secrets[b].Name })
+
+	vaultAddr := i.vaultAddr
+	if value := annotations[annotationPrefix+"vault-addr"]; value != "" {
+		vaultAddr = value
+	}
+
+	env := []corev1.EnvVar{{Name: "VAULT_ADDR", Value: vaultAddr}}
+	if value := annotations[annotationPrefix+"role"]; value != "" {
+		env = append(env, corev1.EnvVar{Name: "KUBE_AUTH_ROLE", Value: value})
+	}
+	if value := annotations[annotationPrefix+"auth-path"]; value != "" {
+		env = append(env, corev1.EnvVar{Name: "KUBE_AUTH_PATH", Value: value})
+	}
+	env = append(env, secrets...)
+
+	mountPath := volumePath
+	if value := annotations[annotationPrefix+"mount-path"]; value != "" {
+		mountPath = value
+	}
+
+	containers, err := i.mountSecrets(pod.Spec.Containers, annotations[annotationPrefix+"containers"], mountPath)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := append(append([]corev1.Volume{}, pod.Spec.Volumes...), corev1.Volume{
+		Name:         volumeName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+	})
+
+	// the init container runs first, so other init containers can use the secrets as well
+	initContainers := append([]corev1.Container{i.container(initContainerName, "init", env)}, pod.Spec.InitContainers...)
+	containers = append(containers, i.container(renewContainerName, "renew", env))
+
+	// arrays are replaced as a whole, as "add" operations on missing arrays would fail
+	return []patchOperation{
+		{Op: "add", Path: "/spec/volumes", Value: volumes},
+		{Op: "add", Path: "/spec/initContainers", Value: initContainers},
+		{Op: "add", Path: "/spec/containers", Value: containers},
+	}, nil
+}
+
+// mountSecrets returns copies of the given containers with the secrets volume mounted into the selected ones
+func (i *Injector) mountSecrets(containers []corev1.Container, selected, mountPath string) ([]corev1.Container, error) {
+	names := map[string]bool{}
+	for _, name := range strings.Split(selected, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names[name] = true
+		}
+	}
+
+	found := map[string]bool{}
+	var mounted []corev1.Container
+	for _, container := range containers {
+		if len(names) == 0 || names[container.Name] {
+			found[container.Name] = true
+			container.VolumeMounts = append(append([]corev1.VolumeMount{}, container.VolumeMounts...), corev1.VolumeMount{
+				Name:      volumeName,
+				MountPath: mountPath,
+				ReadOnly:  true,
+			})
+		}
+
+		mounted = append(mounted, container)
+	}
+
+	for name := range names {
+		if found[name] {
+			continue
+		}
+		return nil, fmt.Errorf("container %q given by %scontainers does not exist", name, annotationPrefix)
+	}
+
+	return mounted, nil
+}
+
+func (i *Injector) container(name, command string, env []corev1.EnvVar) corev1.Container {
+	return corev1.Container{
+		Name:            name,
+		Image:           i.image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args:            []string{command},
+		Env:             env,
+		VolumeMounts: []corev1.VolumeMount{{
+			Name:      volumeName,
+			MountPath: volumePath,
+		}},
+	}
+}
+
+// injected returns whether the kube-vault containers were injected into the given pod already
+func injected(pod *corev1.Pod) bool {
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == initContainerName {
+			return true
+		}
+	}
+
+	return false
+}
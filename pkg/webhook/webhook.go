@@ -0,0 +1,166 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// shutdownTimeout is how long to wait for running requests when shutting down
+	shutdownTimeout = 5 * time.Second
+	// maxBodySize limits the size of admission reviews
+	maxBodySize = 1 << 20
+)
+
+// Webhook serves the mutating admission webhook injecting the kube-vault containers into pods
+type Webhook struct {
+	logger   *logrus.Entry
+	addr     string
+	certFile string
+	keyFile  string
+	injector *Injector
+	mux      *http.ServeMux
+}
+
+// NewWebhook returns a new Webhook instance listening on the given address using the given TLS certificate
+func NewWebhook(logger *logrus.Entry, addr, certFile, keyFile string, injector *Injector) *Webhook {
+	w := &Webhook{
+		logger:   logger,
+		addr:     addr,
+		certFile: certFile,
+		keyFile:  keyFile,
+		injector: injector,
+		mux:      http.NewServeMux(),
+	}
+
+	w.mux.HandleFunc("/mutate", w.handleMutate)
+	w.mux.HandleFunc("/healthz", w.handleHealth)
+
+	return w
+}
+
+// Handler returns the handler serving all endpoints
+func (w *Webhook) Handler() http.Handler {
+	return w.mux
+}
+
+// Run serves the webhook until the context is done
+func (w *Webhook) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    w.addr,
+		Handler: w.mux,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			w.logger.Errorf("failed to shut down webhook server: %v", err)
+		}
+	}()
+
+	w.logger.Infof("Serving admission webhook on %s", w.addr)
+	if err := srv.ListenAndServeTLS(w.certFile, w.keyFile); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("failed to serve admission webhook: %v", err)
+	}
+
+	return nil
+}
+
+func (w *Webhook) handleHealth(rw http.ResponseWriter, r *http.Request) {
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte("ok\n"))
+}
+
+// handleMutate responds to an admission review of a pod with the patch injecting the kube-vault containers
+func (w *Webhook) handleMutate(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		rw.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(rw, r.Body, maxBodySize))
+	if err != nil {
+		http.Error(rw, fmt.Sprintf("failed to read admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review := &admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(body, review); err != nil || review.Request == nil {
+		http.Error(rw, fmt.Sprintf("invalid admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	review.Response = w.mutate(review.Request)
+	review.Response.UID = review.Request.UID
+	review.Request = nil
+
+	response, err := json.Marshal(review)
+	if err != nil {
+		w.logger.Errorf("failed to encode admission review: %v", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write(response)
+}
+
+// mutate admits the pod of the given request, patching it if it references secrets. Pods with invalid annotations
+// are rejected.
+func (w *Webhook) mutate(request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(request.Object.Raw, pod); err != nil {
+		return deny(fmt.Sprintf("failed to decode pod: %v", err))
+	}
+
+	// the name of pods created by controllers is not known yet
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+
+	patch, err := w.injector.inject(pod)
+	if err != nil {
+		w.logger.Warnf("Rejecting pod %s/%s: %v", request.Namespace, name, err)
+		return deny(err.Error())
+	}
+
+	if len(patch) == 0 {
+		return &admissionv1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	content, err := json.Marshal(patch)
+	if err != nil {
+		return deny(fmt.Sprintf("failed to encode patch: %v", err))
+	}
+
+	w.logger.Infof("Injecting kube-vault into pod %s/%s", request.Namespace, name)
+
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     content,
+		PatchType: &patchType,
+	}
+}
+
+func deny(message string) *admissionv1beta1.AdmissionResponse {
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result:  &metav1.Status{Message: message},
+	}
+}
@@ -0,0 +1,188 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	internalTesting "github.com/libri-gmbh/kube-vault/pkg/internal/testing"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// review posts the AdmissionReview fixture with the given name to the webhook and returns the review and the pod
+// patched according to the response
+func review(t *testing.T, fixture string) (*admissionv1beta1.AdmissionReview, *corev1.Pod) {
+	content, err := ioutil.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	request := &admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(content, request); err != nil {
+		t.Fatalf("Failed to decode fixture: %v", err)
+	}
+
+	_, logger := internalTesting.NewLogger()
+	w := NewWebhook(logger, "", "", "", NewInjector("libri/kube-vault", "https://vault:8200"))
+
+	recorder := httptest.NewRecorder()
+	w.Handler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/mutate", bytes.NewReader(content)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Got unexpected status code %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	response := &admissionv1beta1.AdmissionReview{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if response.Response == nil {
+		t.Fatal("Expected response to be set")
+	}
+	if response.Response.UID != request.Request.UID {
+		t.Errorf("Expected response uid %q, got %q", request.Request.UID, response.Response.UID)
+	}
+
+	podJSON := request.Request.Object.Raw
+	if len(response.Response.Patch) > 0 {
+		if response.Response.PatchType == nil || *response.Response.PatchType != admissionv1beta1.PatchTypeJSONPatch {
+			t.Errorf("Expected patch type %s, got %v", admissionv1beta1.PatchTypeJSONPatch, response.Response.PatchType)
+		}
+
+		patch, err := jsonpatch.DecodePatch(response.Response.Patch)
+		if err != nil {
+			t.Fatalf("Failed to decode patch: %v", err)
+		}
+
+		if podJSON, err = patch.Apply(podJSON); err != nil {
+			t.Fatalf("Failed to apply patch: %v", err)
+		}
+	}
+
+	pod := &corev1.Pod{}
+	if err := json.Unmarshal(podJSON, pod); err != nil {
+		t.Fatalf("Failed to decode patched pod: %v", err)
+	}
+
+	return response, pod
+}
+
+func TestWebhook_Mutate(t *testing.T) {
+	response, pod := review(t, "annotated.json")
+	if !response.Response.Allowed {
+		t.Fatalf("Expected pod to be allowed, got %v", response.Response.Result)
+	}
+
+	expectedEnv := []corev1.EnvVar{
+		{Name: "VAULT_ADDR", Value: "https://vault:8200"},
+		{Name: "KUBE_AUTH_ROLE", Value: "dev-example-write"},
+		{Name: "KUBE_AUTH_PATH", Value: "dev/example/k8s"},
+		{Name: "SECRET_AWS", Value: "dev/example/aws/creds/write"},
+		{Name: "SECRET_DB_CREDS", Value: "secret/db"},
+	}
+	expectedMounts := []corev1.VolumeMount{{Name: volumeName, MountPath: volumePath}}
+
+	if len(pod.Spec.InitContainers) != 1 {
+		t.Fatalf("Expected 1 init container, got %d", len(pod.Spec.InitContainers))
+	}
+	if len(pod.Spec.Containers) != 3 {
+		t.Fatalf("Expected 3 containers, got %d", len(pod.Spec.Containers))
+	}
+
+	for _, c := range []struct {
+		container corev1.Container
+		name      string
+		args      []string
+	}{
+		{container: pod.Spec.InitContainers[0], name: initContainerName, args: []string{"init"}},
+		{container: pod.Spec.Containers[2], name: renewContainerName, args: []string{"renew"}},
+	} {
+		if c.container.Name != c.name {
+			t.Errorf("Expected container %q, got %q", c.name, c.container.Name)
+		}
+		if c.container.Image != "libri/kube-vault" {
+			t.Errorf("Expected image of %q to be libri/kube-vault, got %q", c.name, c.container.Image)
+		}
+		if !reflect.DeepEqual(c.container.Args, c.args) {
+			t.Errorf("Expected args of %q to be %v, got %v", c.name, c.args, c.container.Args)
+		}
+		if !reflect.DeepEqual(c.container.Env, expectedEnv) {
+			t.Errorf("Expected env of %q to be %v, got %v", c.name, expectedEnv, c.container.Env)
+		}
+		if !reflect.DeepEqual(c.container.VolumeMounts, expectedMounts) {
+			t.Errorf("Expected volume mounts of %q to be %v, got %v", c.name, expectedMounts, c.container.VolumeMounts)
+		}
+	}
+
+	expectedAppMounts := []corev1.VolumeMount{
+		{Name: "config", MountPath: "/config"},
+		{Name: volumeName, MountPath: volumePath, ReadOnly: true},
+	}
+	if !reflect.DeepEqual(pod.Spec.Containers[0].VolumeMounts, expectedAppMounts) {
+		t.Errorf("Expected volume mounts of app to be %v, got %v", expectedAppMounts, pod.Spec.Containers[0].VolumeMounts)
+	}
+	if len(pod.Spec.Containers[1].VolumeMounts) != 0 {
+		t.Errorf("Expected no volume mounts in metrics, got %v", pod.Spec.Containers[1].VolumeMounts)
+	}
+
+	if len(pod.Spec.Volumes) != 2 || pod.Spec.Volumes[0].Name != "config" {
+		t.Fatalf("Expected config volume to be kept, got %v", pod.Spec.Volumes)
+	}
+	volume := pod.Spec.Volumes[1]
+	if volume.Name != volumeName || volume.EmptyDir == nil || volume.EmptyDir.Medium != corev1.StorageMediumMemory {
+		t.Errorf("Expected in-memory emptyDir %q, got %+v", volumeName, volume)
+	}
+}
+
+func TestWebhook_MutateSkipped(t *testing.T) {
+	for _, fixture := range []string{"plain.json", "injected.json"} {
+		t.Run(fixture, func(t *testing.T) {
+			response, _ := review(t, fixture)
+			if !response.Response.Allowed {
+				t.Errorf("Expected pod to be allowed, got %v", response.Response.Result)
+			}
+			if len(response.Response.Patch) != 0 {
+				t.Errorf("Expected no patch, got %s", response.Response.Patch)
+			}
+		})
+	}
+}
+
+func TestWebhook_MutateInvalid(t *testing.T) {
+	response, _ := review(t, "invalid.json")
+	if response.Response.Allowed {
+		t.Fatal("Expected pod to be denied")
+	}
+	if response.Response.Result == nil || response.Response.Result.Message == "" {
+		t.Errorf("Expected a message explaining the denial, got %v", response.Response.Result)
+	}
+}
+
+func TestWebhook_MutateBadRequest(t *testing.T) {
+	_, logger := internalTesting.NewLogger()
+	w := NewWebhook(logger, "", "", "", NewInjector("libri/kube-vault", ""))
+
+	for method, body := range map[string]string{http.MethodPost: "{", http.MethodGet: ""} {
+		recorder := httptest.NewRecorder()
+		w.Handler().ServeHTTP(recorder, httptest.NewRequest(method, "/mutate", bytes.NewBufferString(body)))
+		if recorder.Code == http.StatusOK {
+			t.Errorf("Expected %s request with body %q to fail", method, body)
+		}
+	}
+}
+
+func TestInjector_InvalidSecret(t *testing.T) {
+	pod := &corev1.Pod{}
+	pod.Annotations = map[string]string{secretAnnotationPrefix + "aws": " "}
+
+	if _, err := NewInjector("libri/kube-vault", "").inject(pod); err == nil {
+		t.Error("Expected error for empty secret path")
+	}
+}